@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	httpdiscovery "github.com/fengxsong/httpsd/pkg/discovery/http"
+)
+
+const defaultTransformerType = "asitis"
+
+// exit codes of the check and transform commands
+const (
+	successExitCode  = 0
+	failureExitCode  = 1
+	syntaxExitCode   = 2
+	semanticExitCode = 3
+)
+
+// checkConfig validates the config files of the http discoverer without
+// starting the server or contacting any upstream.
+func checkConfig(w io.Writer, defaultType string, files ...string) int {
+	code := successExitCode
+	for _, f := range files {
+		fmt.Fprintln(w, "Checking", f)
+		ret, errs := checkConfigFile(f, defaultType)
+		if ret == successExitCode {
+			fmt.Fprintln(w, "  SUCCESS")
+			continue
+		}
+		fmt.Fprintln(w, "  FAILED:")
+		for _, err := range errs {
+			fmt.Fprintln(w, "   ", err)
+		}
+		// syntax errors take precedence over semantic ones
+		if code == successExitCode || (ret != semanticExitCode && code == semanticExitCode) {
+			code = ret
+		}
+	}
+	return code
+}
+
+func checkConfigFile(f, defaultType string) (int, []error) {
+	content, err := os.ReadFile(f)
+	if err != nil {
+		return failureExitCode, []error{err}
+	}
+	cfg, err := httpdiscovery.ParseConfig(content)
+	if err != nil {
+		return syntaxExitCode, []error{fmt.Errorf("%s: %w", f, err)}
+	}
+	lines := sourceLines(content)
+	position := func(index int, field string) string {
+		if index >= len(lines) {
+			return f
+		}
+		if line, ok := lines[index][field]; ok {
+			return fmt.Sprintf("%s:%d", f, line)
+		}
+		return fmt.Sprintf("%s:%d", f, lines[index][""])
+	}
+
+	var errs []error
+	if err = cfg.Validate(defaultType); err != nil {
+		for _, err := range unwrapJoined(err) {
+			var serr *httpdiscovery.SourceError
+			if errors.As(err, &serr) {
+				errs = append(errs, fmt.Errorf("%s: %w", position(serr.Index, serr.Field), err))
+			} else {
+				errs = append(errs, fmt.Errorf("%s: %w", f, err))
+			}
+		}
+	}
+	for i, sc := range cfg.Sources {
+		if sc == nil {
+			continue
+		}
+		if _, err := httpdiscovery.NewTransformer(sc); err != nil {
+			err = &httpdiscovery.SourceError{Index: i, Name: sc.Name, Err: err}
+			errs = append(errs, fmt.Errorf("%s: %w", position(i, "type"), err))
+		}
+		if err := sc.Template.Validate(); err != nil {
+			field := "gotemplate"
+			if sc.Template.GoTemplate == "" {
+				field = "jsonpath"
+			}
+			err = &httpdiscovery.SourceError{Index: i, Name: sc.Name, Err: err}
+			errs = append(errs, fmt.Errorf("%s: %w", position(i, field), err))
+		}
+	}
+	if len(errs) > 0 {
+		return semanticExitCode, errs
+	}
+	return successExitCode, nil
+}
+
+func unwrapJoined(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// sourceLines returns the line numbers of each source and of its keys, the
+// line of the source itself is stored with an empty key.
+func sourceLines(content []byte) []map[string]int {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	items := []*yaml.Node{root}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "sources" && root.Content[i+1].Kind == yaml.SequenceNode {
+			items = root.Content[i+1].Content
+			break
+		}
+	}
+	lines := make([]map[string]int, 0, len(items))
+	for _, item := range items {
+		m := map[string]int{"": item.Line}
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			m[item.Content[i].Value] = item.Content[i].Line
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fengxsong/httpsd/pkg/utils"
+)
+
+const (
+	// versionIdle is how long the version of a request which isn't made
+	// anymore is kept, requests are keyed by their raw query so clients
+	// adding cache busters would grow the versions otherwise.
+	versionIdle = time.Hour
+	// maxVersions bounds the versions kept, the least recently requested
+	// one is evicted first.
+	maxVersions = 10000
+)
+
+// versions tracks when the content served for every distinct request last
+// changed, so that Last-Modified stays put as long as the ETag does.
+type versions struct {
+	mu sync.Mutex
+	m  *utils.IdleMap[contentVersion]
+}
+
+type contentVersion struct {
+	etag     string
+	modified time.Time
+}
+
+// observe records etag for key and returns the time it was first seen.
+func (v *versions) observe(key, etag string, now time.Time) time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.m == nil {
+		v.m = utils.NewIdleMap[contentVersion](versionIdle, maxVersions)
+	}
+	if ver, ok := v.m.Get(key, now); ok && ver.etag == etag {
+		return ver.modified
+	}
+	modified := now.UTC().Truncate(time.Second)
+	v.m.Set(key, contentVersion{etag: etag, modified: modified}, now)
+	return modified
+}
+
+// computeETag returns a weak ETag of b, weak since the pretty and compact
+// encodings of the same targetgroups are semantically equivalent.
+func computeETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified evaluates the conditional headers of req, If-None-Match takes
+// precedence over If-Modified-Since as per RFC 9110.
+func notModified(req *http.Request, etag string, modified time.Time) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.After(t)
+	}
+	return false
+}
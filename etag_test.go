@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVersionsObserve(t *testing.T) {
+	var v versions
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := v.observe("a", "1", start); !got.Equal(start) {
+		t.Fatalf("first observe = %s, want %s", got, start)
+	}
+	if got := v.observe("a", "1", start.Add(time.Minute)); !got.Equal(start) {
+		t.Fatalf("unchanged etag moved Last-Modified to %s", got)
+	}
+	if got := v.observe("a", "2", start.Add(2*time.Minute)); !got.Equal(start.Add(2 * time.Minute)) {
+		t.Fatalf("changed etag kept Last-Modified %s", got)
+	}
+}
+
+func TestVersionsBounded(t *testing.T) {
+	var v versions
+	now := time.Now()
+	for i := 0; i <= maxVersions; i++ {
+		v.observe(fmt.Sprint(i), "1", now)
+	}
+	if got := v.m.Len(); got != maxVersions {
+		t.Fatalf("got %d versions, want %d", got, maxVersions)
+	}
+	v.observe("new", "1", now.Add(versionIdle+2*time.Minute))
+	if got := v.m.Len(); got != 1 {
+		t.Fatalf("got %d versions after idle sweep, want 1", got)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+const (
+	matchParam       = "match[]"
+	labelParamPrefix = "label."
+)
+
+// targetFilter selects targets by their labels merged with the ones of their
+// group, a target is kept if it matches any of the selectors and all of the
+// label matchers.
+type targetFilter struct {
+	selectors [][]*labels.Matcher
+	matchers  []*labels.Matcher
+}
+
+// parseTargetFilter parses the `match[]` and `label.<name>` query parameters
+// and returns the query without them, which is passed to discoverers. The
+// filter is nil if there's none of the parameters.
+//
+// `match[]` takes a series selector, braces are optional. The value of
+// `label.<name>` is matched for equality, prefix it with `~` for a regexp,
+// `!` for inequality or `!~` for a negative regexp.
+func parseTargetFilter(q url.Values) (*targetFilter, url.Values, error) {
+	f := &targetFilter{}
+	rest := url.Values{}
+	for k, vs := range q {
+		switch {
+		case k == matchParam:
+			for _, v := range vs {
+				s := strings.TrimSpace(v)
+				if !strings.HasPrefix(s, "{") {
+					s = "{" + s + "}"
+				}
+				matchers, err := parser.ParseMetricSelector(s)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid %s %q: %w", matchParam, v, err)
+				}
+				f.selectors = append(f.selectors, matchers)
+			}
+		case strings.HasPrefix(k, labelParamPrefix):
+			name := strings.TrimPrefix(k, labelParamPrefix)
+			if !model.LabelName(name).IsValid() {
+				return nil, nil, fmt.Errorf("invalid label name %q of parameter %s", name, k)
+			}
+			for _, v := range vs {
+				m, err := labelMatcher(name, v)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid parameter %s=%s: %w", k, v, err)
+				}
+				f.matchers = append(f.matchers, m)
+			}
+		default:
+			rest[k] = vs
+		}
+	}
+	if len(f.selectors) == 0 && len(f.matchers) == 0 {
+		return nil, rest, nil
+	}
+	return f, rest, nil
+}
+
+func labelMatcher(name, v string) (*labels.Matcher, error) {
+	switch {
+	case strings.HasPrefix(v, "!~"):
+		return labels.NewMatcher(labels.MatchNotRegexp, name, v[2:])
+	case strings.HasPrefix(v, "!"):
+		return labels.NewMatcher(labels.MatchNotEqual, name, v[1:])
+	case strings.HasPrefix(v, "~"):
+		return labels.NewMatcher(labels.MatchRegexp, name, v[1:])
+	default:
+		return labels.NewMatcher(labels.MatchEqual, name, v)
+	}
+}
+
+// filter returns new targetgroups holding the matching targets only.
+func (f *targetFilter) filter(tgs []*targetgroup.Group) []*targetgroup.Group {
+	if f == nil {
+		return tgs
+	}
+	return selectTargets(tgs, func(tg *targetgroup.Group, target model.LabelSet) bool {
+		return f.matches(tg.Labels.Merge(target))
+	})
+}
+
+// selectTargets returns new targetgroups holding the targets keep returns
+// true for, groups left empty are dropped. The given targetgroups are left
+// untouched since they may be cached.
+func selectTargets(tgs []*targetgroup.Group, keep func(tg *targetgroup.Group, target model.LabelSet) bool) []*targetgroup.Group {
+	ret := make([]*targetgroup.Group, 0, len(tgs))
+	for _, tg := range tgs {
+		var targets []model.LabelSet
+		for _, target := range tg.Targets {
+			if keep(tg, target) {
+				targets = append(targets, target)
+			}
+		}
+		if len(targets) > 0 {
+			ret = append(ret, &targetgroup.Group{
+				Targets: targets,
+				Labels:  tg.Labels,
+				Source:  tg.Source,
+			})
+		}
+	}
+	return ret
+}
+
+func (f *targetFilter) matches(ls model.LabelSet) bool {
+	if !matchAll(f.matchers, ls) {
+		return false
+	}
+	if len(f.selectors) == 0 {
+		return true
+	}
+	for _, matchers := range f.selectors {
+		if matchAll(matchers, ls) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAll(matchers []*labels.Matcher, ls model.LabelSet) bool {
+	for _, m := range matchers {
+		if !m.Matches(string(ls[model.LabelName(m.Name)])) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestParseTargetFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		q      url.Values
+		rest   url.Values
+		nilErr bool
+		noop   bool
+	}{
+		{name: "none", q: url.Values{"serviceName": {"a"}}, rest: url.Values{"serviceName": {"a"}}, nilErr: true, noop: true},
+		{name: "selector", q: url.Values{"match[]": {`env="prod"`}, "serviceName": {"a"}}, rest: url.Values{"serviceName": {"a"}}, nilErr: true},
+		{name: "braced selector", q: url.Values{"match[]": {`{env=~"prod|dev"}`}}, rest: url.Values{}, nilErr: true},
+		{name: "label", q: url.Values{"label.env": {"!~dev.*"}}, rest: url.Values{}, nilErr: true},
+		{name: "invalid selector", q: url.Values{"match[]": {`env=`}}},
+		{name: "invalid label name", q: url.Values{"label.a-b": {"x"}}},
+		{name: "invalid regexp", q: url.Values{"label.env": {"~("}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, rest, err := parseTargetFilter(tc.q)
+			if (err == nil) != tc.nilErr {
+				t.Fatalf("got error %v, want error %t", err, !tc.nilErr)
+			}
+			if err != nil {
+				return
+			}
+			if (f == nil) != tc.noop {
+				t.Errorf("got filter %v, want nil %t", f, tc.noop)
+			}
+			if !reflect.DeepEqual(rest, tc.rest) {
+				t.Errorf("rest = %v, want %v", rest, tc.rest)
+			}
+		})
+	}
+}
+
+func TestTargetFilter(t *testing.T) {
+	tgs := []*targetgroup.Group{
+		{
+			Labels: model.LabelSet{"env": "prod"},
+			Targets: []model.LabelSet{
+				{model.AddressLabel: "a:80", "zone": "z1"},
+				{model.AddressLabel: "b:80", "zone": "z2"},
+			},
+			Source: "0",
+		},
+		{
+			Labels:  model.LabelSet{"env": "dev"},
+			Targets: []model.LabelSet{{model.AddressLabel: "c:80", "zone": "z1"}},
+			Source:  "1",
+		},
+	}
+	for _, tc := range []struct {
+		name string
+		q    url.Values
+		want []string
+	}{
+		{name: "no filter", q: url.Values{}, want: []string{"a:80", "b:80", "c:80"}},
+		{name: "group label", q: url.Values{"label.env": {"prod"}}, want: []string{"a:80", "b:80"}},
+		{name: "target label", q: url.Values{"label.zone": {"z1"}}, want: []string{"a:80", "c:80"}},
+		{name: "not equal", q: url.Values{"label.zone": {"!z1"}}, want: []string{"b:80"}},
+		{name: "regexp", q: url.Values{"label.zone": {"~z[12]"}}, want: []string{"a:80", "b:80", "c:80"}},
+		{name: "negative regexp", q: url.Values{"label.env": {"!~pr.*"}}, want: []string{"c:80"}},
+		{name: "labels are anded", q: url.Values{"label.env": {"prod"}, "label.zone": {"z1"}}, want: []string{"a:80"}},
+		{name: "selectors are ored", q: url.Values{"match[]": {`env="dev"`, `zone="z2"`}}, want: []string{"b:80", "c:80"}},
+		{name: "selector and label", q: url.Values{"match[]": {`{zone="z1"}`}, "label.env": {"dev"}}, want: []string{"c:80"}},
+		{name: "missing label is empty", q: url.Values{"label.rack": {""}}, want: []string{"a:80", "b:80", "c:80"}},
+		{name: "nothing", q: url.Values{"label.env": {"staging"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, _, err := parseTargetFilter(tc.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tg := range f.filter(tgs) {
+				for _, target := range tg.Targets {
+					got = append(got, string(target[model.AddressLabel]))
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("targets = %v, want %v", got, tc.want)
+			}
+		})
+	}
+	if len(tgs[0].Targets) != 2 || len(tgs[1].Targets) != 1 {
+		t.Error("filter mutated its input")
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	tgs := []*targetgroup.Group{
+		{
+			Labels:  model.LabelSet{"env": "prod"},
+			Targets: []model.LabelSet{{model.AddressLabel: "a:80"}, {model.AddressLabel: "b:80"}},
+			Source:  "0",
+		},
+		{
+			Labels:  model.LabelSet{"env": "dev"},
+			Targets: []model.LabelSet{{model.AddressLabel: "c:80"}},
+			Source:  "1",
+		},
+	}
+	got := selectTargets(tgs, func(tg *targetgroup.Group, target model.LabelSet) bool {
+		return target[model.AddressLabel] != "a:80" && tg.Labels["env"] == "prod"
+	})
+	want := []*targetgroup.Group{{
+		Labels:  model.LabelSet{"env": "prod"},
+		Targets: []model.LabelSet{{model.AddressLabel: "b:80"}},
+		Source:  "0",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(tgs[0].Targets) != 2 {
+		t.Error("selectTargets mutated its input")
+	}
+}
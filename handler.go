@@ -1,98 +1,336 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
 
 	"github.com/fengxsong/httpsd/pkg/discovery"
+	_ "github.com/fengxsong/httpsd/pkg/discovery/consul"
 	_ "github.com/fengxsong/httpsd/pkg/discovery/http"
 	_ "github.com/fengxsong/httpsd/pkg/discovery/nacos"
+	"github.com/fengxsong/httpsd/pkg/output"
+	"github.com/fengxsong/httpsd/pkg/utils"
 )
 
 type options struct {
-	path string
-	t    string
+	path              string
+	t                 string
+	readyMaxStaleness time.Duration
+	relabelConfigPath string
 }
 
 func (o *options) AddFlags(app *kingpin.Application) {
 	app.Flag("uri.path", "path of target url").Default("/targets").StringVar(&o.path)
 	app.Flag("discoverer.type", "type of discoverer").Default("http").StringVar(&o.t)
+	app.Flag("web.ready-max-staleness", "report not ready if the last completed sync of any discoverer is older than this, 0 disables the check").Default("0s").DurationVar(&o.readyMaxStaleness)
+	app.Flag("relabel.config", "path of file of relabel configs per discoverer, which are applied to the targets of the discoverer").Default("").StringVar(&o.relabelConfigPath)
 }
 
 type sdHandler struct {
-	defaultT   string
+	defaultT          string
+	path              string
+	relabelConfigPath string
+	readyMaxStaleness time.Duration
+	logger            log.Logger
+	registerer        prometheus.Registerer
+
+	mu         sync.RWMutex
 	discoverer map[string]discovery.Discoverer
-	logger     log.Logger
+	// buildErrors holds the discoverers skipped by the last reload
+	buildErrors    map[string]string
+	relabelConfigs map[string][]*relabel.Config
+
+	refreshesMu sync.Mutex
+	refreshes   map[string]refreshStatus
+
+	configSuccess     prometheus.Gauge
+	configSuccessTime prometheus.Gauge
+
+	versions versions
+}
+
+// apiError is the body of error responses.
+type apiError struct {
+	Code           int    `json:"code"`
+	Kind           string `json:"kind"`
+	Message        string `json:"message"`
+	Discoverer     string `json:"discoverer,omitempty"`
+	UpstreamStatus int    `json:"upstreamStatus,omitempty"`
+}
+
+// statusCodes maps kinds of errors to HTTP status, other kinds are 500.
+var statusCodes = map[string]int{
+	discovery.KindInvalidQuery:    http.StatusBadRequest,
+	discovery.KindNotFound:        http.StatusNotFound,
+	discovery.KindUpstream:        http.StatusBadGateway,
+	discovery.KindInvalidResponse: http.StatusBadGateway,
+	discovery.KindTimeout:         http.StatusGatewayTimeout,
 }
 
-func httpErrorWithLogging(w http.ResponseWriter, logger log.Logger, err string, code int) {
+func httpErrorWithLogging(w http.ResponseWriter, logger log.Logger, discoverer string, err error) {
+	body := apiError{
+		Code:       http.StatusInternalServerError,
+		Kind:       discovery.KindOf(err),
+		Message:    err.Error(),
+		Discoverer: discoverer,
+	}
+	if code, ok := statusCodes[body.Kind]; ok {
+		body.Code = code
+	}
+	var e *discovery.Error
+	if errors.As(err, &e) {
+		body.UpstreamStatus = e.StatusCode
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(code)
-	fmt.Fprintf(w, `{"error": "%s"}`, err)
-	level.Error(logger).Log("err", err)
+	w.WriteHeader(body.Code)
+	json.NewEncoder(w).Encode(body)
+
+	l := level.Error(logger)
+	if body.Code < http.StatusInternalServerError {
+		l = level.Warn(logger)
+	}
+	l.Log("discoverer", discoverer, "kind", body.Kind, "err", err)
 }
 
 func (h *sdHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	q := req.URL.Query()
 	pretty, _ := strconv.ParseBool(q.Get("pretty"))
 	q.Del("pretty")
+	encoder, err := output.Select(q.Get("format"), req.Header.Get("Accept"))
+	if err != nil {
+		httpErrorWithLogging(w, h.logger, "", discovery.NewError(discovery.KindInvalidQuery, err))
+		return
+	}
+	q.Del("format")
 
+	t, targetgroups, err := h.targets(req.Context(), q)
+	if err != nil {
+		httpErrorWithLogging(w, h.logger, t, err)
+		return
+	}
+	out := bytes.NewBuffer(nil)
+	if err = encoder.Encode(out, targetgroups, pretty); err != nil {
+		httpErrorWithLogging(w, h.logger, t, err)
+		return
+	}
+	etag := computeETag(out.Bytes())
+	// the encoded output is versioned, so the format is part of the key
+	key := fmt.Sprintf("%s?%s %s %t", t, q.Encode(), encoder.Name(), pretty)
+	modified := h.versions.observe(key, etag, time.Now())
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	if notModified(req, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", encoder.ContentType())
+	w.Write(out.Bytes())
+}
+
+// targets refreshes the discoverer selected by the `discovery` query
+// parameter and returns its name along with the targetgroups, which are
+// filtered by the `match[]` and `label.<name>` parameters then sharded.
+func (h *sdHandler) targets(ctx context.Context, q url.Values) (string, []*targetgroup.Group, error) {
 	t := q.Get("discovery")
 	if t == "" {
 		t = h.defaultT
 	}
+	filter, params, err := parseTargetFilter(q)
+	if err != nil {
+		return t, nil, discovery.NewError(discovery.KindInvalidQuery, err)
+	}
+	sharding, err := parseSharding(params)
+	if err != nil {
+		return t, nil, discovery.NewError(discovery.KindInvalidQuery, err)
+	}
 
-	discovery := h.discoverer[t]
-	if discovery == nil {
-		if len(h.discoverer) > 1 {
-			httpErrorWithLogging(w, h.logger, fmt.Sprintf("unknown discoverer %s", t), http.StatusInternalServerError)
-			return
+	t, discoverer, relabelConfigs, err := h.lookup(t)
+	if err != nil {
+		return t, nil, err
+	}
+	start := time.Now()
+	targetgroups, err := discoverer.Refresh(ctx, params)
+	if err == nil && len(relabelConfigs) > 0 {
+		targetgroups = utils.Grouping(utils.Relabel(targetgroups, relabelConfigs...))
+	}
+	targetgroups = sharding.filter(filter.filter(targetgroups))
+	h.observeRefresh(t, q, start, targetgroups, err)
+	return t, targetgroups, err
+}
+
+// lookup returns the named discoverer along with its relabel configs, the
+// only discoverer is returned whatever the name is if there's only one.
+func (h *sdHandler) lookup(t string) (string, discovery.Discoverer, []*relabel.Config, error) {
+	h.mu.RLock()
+	discoverers, relabelConfigs := h.discoverer, h.relabelConfigs
+	h.mu.RUnlock()
+
+	discoverer := discoverers[t]
+	if discoverer == nil {
+		if len(discoverers) > 1 {
+			return t, nil, nil, discovery.NewError(discovery.KindNotFound, fmt.Errorf("unknown discoverer %s", t))
 		}
 		// use the only one
-		for _, d := range h.discoverer {
-			discovery = d
+		for name, d := range discoverers {
+			t, discoverer = name, d
 			break
 		}
 	}
-	targetgroups, err := discovery.Refresh(req.Context(), q)
-	if err != nil {
-		httpErrorWithLogging(w, h.logger, err.Error(), http.StatusInternalServerError)
-		return
+	return t, discoverer, relabelConfigs[t], nil
+}
+
+// ready returns false if the discoverer selected by q syncs in background
+// and hasn't completed its first sync yet.
+func (h *sdHandler) ready(q url.Values) bool {
+	t := q.Get("discovery")
+	if t == "" {
+		t = h.defaultT
 	}
-	encoder := json.NewEncoder(w)
-	if pretty {
-		encoder.SetIndent("", "  ")
+	_, discoverer, _, err := h.lookup(t)
+	if err != nil {
+		return true
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if err = encoder.Encode(targetgroups); err != nil {
-		httpErrorWithLogging(w, h.logger, err.Error(), http.StatusInternalServerError)
+	if r, ok := discoverer.(discovery.StatusReporter); ok {
+		return r.Status().Ready
 	}
+	return true
 }
 
 func newSDHandler(o *options, logger log.Logger, registerer prometheus.Registerer) (*sdHandler, error) {
 	handler := &sdHandler{
-		defaultT:   o.t,
-		discoverer: map[string]discovery.Discoverer{},
-		logger:     logger,
+		defaultT:          o.t,
+		path:              o.path,
+		relabelConfigPath: o.relabelConfigPath,
+		readyMaxStaleness: o.readyMaxStaleness,
+		logger:            logger,
+		registerer:        registerer,
+		configSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "httpsd",
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		}),
+		configSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "httpsd",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		}),
 	}
+	registerer.MustRegister(handler.configSuccess, handler.configSuccessTime)
+	if err := handler.reload(); err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+// reload rebuilds the discoverers configured by files along with the ones
+// which failed to build before, and swaps them in at once. Running
+// discoverers configured by flags only are kept as they are. The current
+// ones are kept if the default discoverer or any of the current ones fails.
+func (h *sdHandler) reload() (err error) {
+	defer func() {
+		if err != nil {
+			h.configSuccess.Set(0)
+			return
+		}
+		h.configSuccess.Set(1)
+		h.configSuccessTime.SetToCurrentTime()
+	}()
+
+	relabelConfigs, err := loadRelabelConfigs(h.relabelConfigPath)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	current := h.discoverer
+	h.mu.RUnlock()
+
+	discoverers := map[string]discovery.Discoverer{}
+	buildErrors := map[string]string{}
+	rebuilt := map[string]discovery.Discoverer{}
 	for name, builder := range discovery.All() {
-		d, err := builder.Build(logger, registerer)
+		if d, ok := current[name]; ok && !discovery.IsReloadable(builder) {
+			discoverers[name] = d
+			continue
+		}
+		d, err := builder.Build(h.logger, h.registerer)
 		if d == nil || err != nil {
-			level.Info(logger).Log("msg", fmt.Sprintf("skip discoverer %s due to err: %s", name, err))
+			if _, ok := current[name]; ok {
+				stopAll(rebuilt)
+				return fmt.Errorf("failed to rebuild discoverer %s: %v", name, err)
+			}
+			level.Info(h.logger).Log("msg", fmt.Sprintf("skip discoverer %s due to err: %s", name, err))
+			buildErrors[name] = fmt.Sprint(err)
 			continue
 		}
-		handler.discoverer[name] = d
+		discoverers[name] = d
+		rebuilt[name] = d
 	}
-	if _, ok := handler.discoverer[o.t]; !ok {
-		return nil, fmt.Errorf("unknown discoverer %s", o.t)
+	if _, ok := discoverers[h.defaultT]; !ok {
+		stopAll(rebuilt)
+		return fmt.Errorf("unknown discoverer %s", h.defaultT)
+	}
+	for name := range relabelConfigs {
+		if _, ok := discovery.All()[name]; !ok {
+			stopAll(rebuilt)
+			return fmt.Errorf("relabel configs of unknown discoverer %s", name)
+		}
+	}
+
+	h.mu.Lock()
+	h.discoverer = discoverers
+	h.buildErrors = buildErrors
+	h.relabelConfigs = relabelConfigs
+	h.mu.Unlock()
+	replaced := map[string]discovery.Discoverer{}
+	for name := range rebuilt {
+		if d, ok := current[name]; ok {
+			replaced[name] = d
+		}
+	}
+	stopAll(replaced)
+	return nil
+}
+
+// loadRelabelConfigs reads the relabel configs keyed by discoverer name.
+func loadRelabelConfigs(path string) (map[string][]*relabel.Config, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfgs map[string][]*relabel.Config
+	if err = yaml.UnmarshalStrict(content, &cfgs); err != nil {
+		return nil, fmt.Errorf("failed to parse relabel configs %s: %w", path, err)
+	}
+	return cfgs, nil
+}
+
+func stopAll(discoverers map[string]discovery.Discoverer) {
+	for _, d := range discoverers {
+		if s, ok := d.(discovery.Stopper); ok {
+			s.Stop()
+		}
 	}
-	return handler, nil
 }
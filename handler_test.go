@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/fengxsong/httpsd/pkg/discovery"
+)
+
+type groupsDiscoverer []*targetgroup.Group
+
+func (d groupsDiscoverer) Refresh(context.Context, url.Values) ([]*targetgroup.Group, error) {
+	return d, nil
+}
+
+func TestTargetsRelabelPerDiscoverer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relabel.yaml")
+	err := os.WriteFile(path, []byte(`
+eureka:
+- source_labels: [env, __meta_zone]
+  regex: prod;a
+  action: keep
+- regex: __meta_(.+)
+  action: labelmap
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relabelConfigs, err := loadRelabelConfigs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgs := groupsDiscoverer{{
+		Labels: model.LabelSet{"env": "prod"},
+		Targets: []model.LabelSet{
+			{model.AddressLabel: "a:80", "__meta_zone": "a"},
+			{model.AddressLabel: "b:80", "__meta_zone": "b"},
+		},
+	}}
+	h := &sdHandler{
+		defaultT:       "http",
+		discoverer:     map[string]discovery.Discoverer{"http": tgs, "eureka": tgs},
+		relabelConfigs: relabelConfigs,
+	}
+	for _, tc := range []struct {
+		discoverer string
+		want       []*targetgroup.Group
+	}{
+		{
+			discoverer: "eureka",
+			want: []*targetgroup.Group{{
+				Labels:  model.LabelSet{"env": "prod", "__meta_zone": "a", "zone": "a"},
+				Targets: []model.LabelSet{{model.AddressLabel: "a:80"}},
+			}},
+		},
+		// no relabel configs, targetgroups are served as they are
+		{discoverer: "http", want: tgs},
+	} {
+		_, got, err := h.targets(context.Background(), url.Values{"discovery": {tc.discoverer}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.discoverer, got, tc.want)
+		}
+	}
+}
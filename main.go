@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -16,8 +19,10 @@ import (
 	"github.com/prometheus/common/version"
 	"github.com/prometheus/exporter-toolkit/web"
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
 
 	"github.com/fengxsong/httpsd/pkg/discovery"
+	"github.com/fengxsong/httpsd/pkg/filesd"
 )
 
 func main() {
@@ -36,10 +41,34 @@ func run() int {
 
 	o := &options{}
 	o.AddFlags(app)
+	fo := &filesd.Options{}
+	fo.AddFlags(app)
+
+	app.Command("serve", "Serve the service discovery endpoints.").Default()
+
+	checkCmd := app.Command("check", "Check the resources for validity.")
+	checkConfigCmd := checkCmd.Command("config", "Check if the config files of the http discoverer are valid or not.")
+	checkConfigFiles := checkConfigCmd.Arg("config-files", "The config files to check.").Required().Strings()
+	checkConfigType := checkConfigCmd.Flag("default-type", "transformer type of sources without one").Default(defaultTransformerType).String()
+
+	to := &transformOptions{}
+	transformCmd := app.Command("transform", "Transform a local response body into targetgroups.")
+	transformCmd.Flag("type", "transformer type, overrides the one of the source").Default("").StringVar(&to.ttype)
+	transformCmd.Flag("config", "path of config file of the http discoverer").Default("").StringVar(&to.config)
+	transformCmd.Flag("source", "name of the source in config file, the first one is used if absent").Default("").StringVar(&to.source)
+	transformCmd.Flag("input", "path of response body to transform").Required().StringVar(&to.input)
+	transformCmd.Flag("expected", "path of expected targetgroups to diff the output against").Default("").StringVar(&to.expected)
+	transformCmd.Flag("query", "query of the targets endpoint passed to the transformer, eg. status=UP").Default("").StringVar(&to.query)
 
 	app.Version(version.Print("httpsd"))
 	app.HelpFlag.Short('h')
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	case checkConfigCmd.FullCommand():
+		return checkConfig(os.Stdout, *checkConfigType, *checkConfigFiles...)
+	case transformCmd.FullCommand():
+		return transform(os.Stdout, to)
+	}
+
 	logger := promlog.New(promlogConfig)
 
 	reg := prometheus.NewRegistry()
@@ -50,17 +79,54 @@ func run() int {
 		return 1
 	}
 
+	writer, err := fo.Build(func(ctx context.Context, q url.Values) ([]*targetgroup.Group, error) {
+		if !handler.ready(q) {
+			return nil, filesd.ErrNotReady
+		}
+		_, tgs, err := handler.targets(ctx, q)
+		return tgs, err
+	}, logger)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return 1
+	}
+	if writer != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go writer.Run(ctx)
+	}
+
 	http.Handle(o.path, handler)
 	http.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Healthy"))
 	})
+	http.HandleFunc("/-/ready", handler.serveReady)
+	http.HandleFunc("/status", handler.serveStatus)
+	http.HandleFunc("/status/targets", handler.serveStatusTargets)
+	http.HandleFunc("/api/v1/status", handler.serveStatusAPI)
 	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
 
+	reloadCh := make(chan chan error)
+	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("Only POST or PUT requests allowed"))
+			return
+		}
+		rc := make(chan error)
+		reloadCh <- rc
+		if err := <-rc; err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		}
+	})
+
 	srv := &http.Server{}
 	srvc := make(chan struct{})
 	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
 	go func() {
 		if err := web.ListenAndServe(srv, toolkitFlags, logger); err != nil {
@@ -76,6 +142,20 @@ func run() int {
 			return 0
 		case <-srvc:
 			return 1
+		case <-hup:
+			if err := handler.reload(); err != nil {
+				level.Error(logger).Log("msg", "Error reloading config", "err", err)
+			} else {
+				level.Info(logger).Log("msg", "Reloaded config")
+			}
+		case rc := <-reloadCh:
+			err := handler.reload()
+			if err != nil {
+				level.Error(logger).Log("msg", "Error reloading config", "err", err)
+			} else {
+				level.Info(logger).Log("msg", "Reloaded config")
+			}
+			rc <- err
 		}
 	}
 }
@@ -0,0 +1,60 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+)
+
+// csvDecoder decodes a table with a header row into `{"records": [...]}`,
+// every record is keyed by the names of the header.
+type csvDecoder struct {
+	name         string
+	contentTypes []string
+	comma        rune
+}
+
+func (d csvDecoder) Name() string { return d.name }
+
+func (d csvDecoder) ContentTypes() []string { return d.contentTypes }
+
+func (d csvDecoder) Decode(b []byte) (any, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))))
+	r.Comma = d.comma
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	records := []any{}
+	if len(rows) == 0 {
+		return map[string]any{"records": records}, nil
+	}
+	header := rows[0]
+	seen := make(map[string]struct{}, len(header))
+	for _, name := range header {
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("duplicated column %q", name)
+		}
+		seen[name] = struct{}{}
+	}
+	for _, row := range rows[1:] {
+		record := make(map[string]any, len(header))
+		for i, name := range header {
+			record[name] = row[i]
+		}
+		records = append(records, record)
+	}
+	return map[string]any{"records": records}, nil
+}
+
+func init() {
+	for _, d := range []csvDecoder{
+		{name: "csv", contentTypes: []string{"text/csv", "application/csv"}, comma: ','},
+		{name: "tsv", contentTypes: []string{"text/tab-separated-values"}, comma: '\t'},
+	} {
+		if err := Register(d.name, func() Decoder { return d }); err != nil {
+			panic(err)
+		}
+	}
+}
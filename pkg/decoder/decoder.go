@@ -0,0 +1,104 @@
+package decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// JSON is the name of the decoder of JSON bodies, which are passed to
+// transformers as they are.
+const JSON = "json"
+
+// Decoder decodes response bodies of upstream into generic data, which is
+// made of maps keyed by string, slices and scalars as if it was decoded
+// from JSON.
+type Decoder interface {
+	Name() string
+	// ContentTypes are the media types of bodies the decoder is selected
+	// for by the Content-Type header of responses.
+	ContentTypes() []string
+	Decode([]byte) (any, error)
+}
+
+type Factory func() Decoder
+
+var decoders = map[string]Factory{}
+
+func Register(name string, factory Factory) error {
+	if _, ok := decoders[name]; ok {
+		return fmt.Errorf("already registered decoder %s", name)
+	}
+	decoders[name] = factory
+	return nil
+}
+
+// Get returns a new instance of the named decoder, nil if it's unknown.
+func Get(name string) Decoder {
+	factory, ok := decoders[name]
+	if !ok {
+		return nil
+	}
+	return factory()
+}
+
+// Names returns the names of all registered decoders.
+func Names() []string {
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForContentType returns the decoder of the media type of a Content-Type
+// header, nil if there's none.
+func ForContentType(contentType string) Decoder {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	for _, name := range Names() {
+		d := Get(name)
+		if slices.Contains(d.ContentTypes(), mediaType) {
+			return d
+		}
+	}
+	// structured syntax suffixes, eg. application/vnd.foo+json
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		return Get(mediaType[i+1:])
+	}
+	return nil
+}
+
+// Accept returns the value of an Accept header listing the content types of
+// all registered decoders, JSON is preferred over the others.
+func Accept() string {
+	types := Get(JSON).ContentTypes()
+	for _, name := range Names() {
+		if name == JSON {
+			continue
+		}
+		for _, contentType := range Get(name).ContentTypes() {
+			types = append(types, contentType+";q=0.9")
+		}
+	}
+	return strings.Join(types, ", ")
+}
+
+// ToJSON decodes b by d and encodes the result as JSON, JSON bodies are
+// returned as they are.
+func ToJSON(d Decoder, b []byte) ([]byte, error) {
+	if d.Name() == JSON {
+		return b, nil
+	}
+	v, err := d.Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", d.Name(), err)
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,186 @@
+package decoder
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForContentType(t *testing.T) {
+	for contentType, want := range map[string]string{
+		"application/json":                   "json",
+		"application/json; charset=utf-8":    "json",
+		"application/x-yaml":                 "yaml",
+		"text/yaml":                          "yaml",
+		"text/xml; charset=UTF-8":            "xml",
+		"application/soap+xml":               "xml",
+		"application/vnd.inventory+json":     "json",
+		"text/csv":                           "csv",
+		"text/tab-separated-values":          "tsv",
+		"text/plain":                         "",
+		"":                                   "",
+		"application/vnd.inventory+protobuf": "",
+	} {
+		var got string
+		if d := ForContentType(contentType); d != nil {
+			got = d.Name()
+		}
+		if got != want {
+			t.Errorf("ForContentType(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		decoder string
+		in      string
+		want    string
+		err     string
+	}{
+		{
+			name:    "json is passed as it is",
+			decoder: "json",
+			in:      `{"a": 1}`,
+			want:    `{"a": 1}`,
+		},
+		{
+			name:    "yaml",
+			decoder: "yaml",
+			in:      "hosts:\n  - ip: 10.0.0.1\n    port: 80\n    tags: [a, b]\n  - ip: 10.0.0.2\n    port: 81\n    enabled: false\n",
+			want:    `{"hosts":[{"ip":"10.0.0.1","port":80,"tags":["a","b"]},{"enabled":false,"ip":"10.0.0.2","port":81}]}`,
+		},
+		{
+			name:    "yaml keys which aren't strings",
+			decoder: "yaml",
+			in:      "ports:\n  80: http\n  true: yes\n",
+			want:    `{"ports":{"80":"http","true":"yes"}}`,
+		},
+		{
+			name:    "invalid yaml",
+			decoder: "yaml",
+			in:      "a: [b\n",
+			err:     "failed to decode yaml",
+		},
+		{
+			name:    "xml",
+			decoder: "xml",
+			in: `<?xml version="1.0" encoding="UTF-8"?>
+<inventory xmlns="urn:inventory" version="2">
+  <host id="a" port="80">10.0.0.1</host>
+  <host id="b" port="81">10.0.0.2</host>
+  <owner>ops</owner>
+  <empty/>
+</inventory>`,
+			want: `{"inventory":{"version":"2","host":[{"id":"a","port":"80","_text":"10.0.0.1"},{"id":"b","port":"81","_text":"10.0.0.2"}],"owner":"ops","empty":""}}`,
+		},
+		{
+			name:    "xml single child isn't a list",
+			decoder: "xml",
+			in:      `<hosts><host><ip>10.0.0.1</ip><ns:port xmlns:ns="urn:x">80</ns:port></host></hosts>`,
+			want:    `{"hosts":{"host":{"ip":"10.0.0.1","port":"80"}}}`,
+		},
+		{
+			name:    "xml attribute and child of the same name",
+			decoder: "xml",
+			in:      `<host ip="10.0.0.1"><ip>10.0.0.2</ip></host>`,
+			want:    `{"host":{"ip":["10.0.0.1","10.0.0.2"]}}`,
+		},
+		{
+			name:    "xml without root",
+			decoder: "xml",
+			in:      `<?xml version="1.0"?>`,
+			err:     "no root element",
+		},
+		{
+			name:    "xml unclosed",
+			decoder: "xml",
+			in:      `<hosts><host>`,
+			err:     "failed to decode xml",
+		},
+		{
+			name:    "csv",
+			decoder: "csv",
+			in:      "\xef\xbb\xbfip, port,env\n10.0.0.1,80,prod\n\"10.0.0.2\",81,\"dev, test\"\n",
+			want:    `{"records":[{"ip":"10.0.0.1","port":"80","env":"prod"},{"ip":"10.0.0.2","port":"81","env":"dev, test"}]}`,
+		},
+		{
+			name:    "csv header only",
+			decoder: "csv",
+			in:      "ip,port\n",
+			want:    `{"records":[]}`,
+		},
+		{
+			name:    "csv empty",
+			decoder: "csv",
+			in:      "",
+			want:    `{"records":[]}`,
+		},
+		{
+			name:    "csv ragged",
+			decoder: "csv",
+			in:      "ip,port\n10.0.0.1\n",
+			err:     "wrong number of fields",
+		},
+		{
+			name:    "csv duplicated column",
+			decoder: "csv",
+			in:      "ip,ip\n1,2\n",
+			err:     "duplicated column",
+		},
+		{
+			name:    "tsv",
+			decoder: "tsv",
+			in:      "ip\tport\n10.0.0.1\t80\n",
+			want:    `{"records":[{"ip":"10.0.0.1","port":"80"}]}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := Get(tc.decoder)
+			if d == nil {
+				t.Fatalf("decoder %s not registered", tc.decoder)
+			}
+			b, err := ToJSON(d, []byte(tc.in))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want any
+			if err = json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("invalid json %s: %v", b, err)
+			}
+			if err = json.Unmarshal([]byte(tc.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %s, want %s", b, tc.want)
+			}
+		})
+	}
+}
+
+func TestAccept(t *testing.T) {
+	accept := Accept()
+	if !strings.HasPrefix(accept, "application/json, text/json, ") {
+		t.Errorf("JSON not preferred in %q", accept)
+	}
+	for _, name := range Names() {
+		for _, contentType := range Get(name).ContentTypes() {
+			if !strings.Contains(accept, contentType) {
+				t.Errorf("%s of %s missing in %q", contentType, name, accept)
+			}
+		}
+	}
+	for _, part := range strings.Split(accept, ",") {
+		if d := ForContentType(strings.TrimSpace(part)); d == nil {
+			t.Errorf("no decoder of %q", part)
+		}
+	}
+}
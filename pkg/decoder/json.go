@@ -0,0 +1,26 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+type jsonDecoder struct{}
+
+func (jsonDecoder) Name() string { return JSON }
+
+func (jsonDecoder) ContentTypes() []string { return []string{"application/json", "text/json"} }
+
+func (jsonDecoder) Decode(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	return v, err
+}
+
+func init() {
+	if err := Register(JSON, func() Decoder { return jsonDecoder{} }); err != nil {
+		panic(err)
+	}
+}
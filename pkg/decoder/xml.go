@@ -0,0 +1,96 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// textKey holds the character data of elements having attributes or child
+// elements as well.
+const textKey = "_text"
+
+// xmlDecoder decodes a document into a map keyed by the name of its root
+// element. Elements holding nothing but text are decoded into strings,
+// others into maps of their attributes and child elements by local name,
+// child elements of the same name are collected into a slice.
+type xmlDecoder struct{}
+
+func (xmlDecoder) Name() string { return "xml" }
+
+func (xmlDecoder) ContentTypes() []string { return []string{"application/xml", "text/xml"} }
+
+func (xmlDecoder) Decode(b []byte) (any, error) {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil, errors.New("no root element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := decodeElement(dec, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{start.Name.Local: v}, nil
+		}
+	}
+}
+
+func decodeElement(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	m := map[string]any{}
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		add(m, attr.Name.Local, attr.Value)
+	}
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			v, err := decodeElement(dec, tok)
+			if err != nil {
+				return nil, err
+			}
+			add(m, tok.Name.Local, v)
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(m) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				add(m, textKey, s)
+			}
+			return m, nil
+		}
+	}
+}
+
+func add(m map[string]any, k string, v any) {
+	switch prev := m[k].(type) {
+	case nil:
+		m[k] = v
+	case []any:
+		m[k] = append(prev, v)
+	default:
+		m[k] = []any{prev, v}
+	}
+}
+
+func init() {
+	if err := Register("xml", func() Decoder { return xmlDecoder{} }); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,54 @@
+package decoder
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+type yamlDecoder struct{}
+
+func (yamlDecoder) Name() string { return "yaml" }
+
+func (yamlDecoder) ContentTypes() []string {
+	return []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}
+}
+
+// Decode decodes the first document of b, keys of mappings which aren't
+// strings are formatted as strings.
+func (yamlDecoder) Decode(b []byte) (any, error) {
+	var v any
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return stringKeys(v), nil
+}
+
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			v[k] = stringKeys(child)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, child := range v {
+			m[fmt.Sprint(k)] = stringKeys(child)
+		}
+		return m
+	case []any:
+		for i, child := range v {
+			v[i] = stringKeys(child)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func init() {
+	if err := Register("yaml", func() Decoder { return yamlDecoder{} }); err != nil {
+		panic(err)
+	}
+}
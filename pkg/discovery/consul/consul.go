@@ -0,0 +1,356 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/fengxsong/httpsd/pkg/discovery"
+	"github.com/fengxsong/httpsd/pkg/utils"
+)
+
+const (
+	name = "consul"
+	// same as prometheus consul sd
+	tagSeparator = ","
+)
+
+type options struct {
+	address     string
+	token       string
+	datacenter  string
+	timeout     time.Duration
+	concurrency int
+
+	// metrics are shared by all discoverers built on reload
+	duration *prometheus.HistogramVec
+	failures *prometheus.CounterVec
+}
+
+func (o *options) AddFlags(app *kingpin.Application) {
+	app.Flag("consul.address", "address of consul http api, eg. http://localhost:8500").Default("").StringVar(&o.address)
+	app.Flag("consul.token", "acl token of consul").Default("").StringVar(&o.token)
+	app.Flag("consul.datacenter", "default datacenter, the one of the agent is used if absent").Default("").StringVar(&o.datacenter)
+	app.Flag("consul.timeout", "timeout of requests to consul").Default("30s").DurationVar(&o.timeout)
+	app.Flag("consul.concurrency", "max number of services fetched concurrently").Default("8").IntVar(&o.concurrency)
+}
+
+func (o *options) Build(logger log.Logger, registerer prometheus.Registerer) (discovery.Discoverer, error) {
+	if o.address == "" {
+		return nil, errors.New("--consul.address is missing")
+	}
+	base, err := url.Parse(o.address)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("scheme of --consul.address must be 'http' or 'https'")
+	}
+	if o.concurrency < 1 {
+		return nil, fmt.Errorf("--consul.concurrency must be positive")
+	}
+	if o.duration == nil {
+		o.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Subsystem: name,
+			Name:      "scrape_duration",
+			Help:      "duration of service discovery process",
+		}, []string{"service"})
+		o.failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: name,
+			Name:      "failures_total",
+			Help:      "number of failed requests to consul",
+		}, []string{"kind"})
+		registerer.MustRegister(o.duration, o.failures)
+	}
+	return &impl{
+		o:        o,
+		base:     base,
+		client:   &http.Client{Timeout: o.timeout},
+		logger:   log.With(logger, "discoverer", name),
+		duration: o.duration,
+		failures: o.failures,
+	}, nil
+}
+
+type impl struct {
+	o      *options
+	base   *url.URL
+	client *http.Client
+	logger log.Logger
+
+	duration *prometheus.HistogramVec
+	failures *prometheus.CounterVec
+}
+
+// Refresh lists the instances of the services given by the `service` query
+// parameters, or of all services if absent. Other supported parameters are
+// `dc`, `tag` and `node-meta` (key:value), which can be repeated, and
+// `passing` to return healthy instances only.
+func (impl *impl) Refresh(ctx context.Context, q url.Values) ([]*targetgroup.Group, error) {
+	services := q["service"]
+	if len(services) == 0 {
+		var err error
+		services, err = impl.listServices(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([][]*targetgroup.Group, len(services))
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(impl.o.concurrency)
+	for i := range services {
+		eg.Go(func() error {
+			tgs, err := impl.getTargetgroupForService(ctx, services[i], q)
+			if err != nil {
+				return err
+			}
+			results[i] = tgs
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	var tgs []*targetgroup.Group
+	for _, r := range results {
+		tgs = append(tgs, r...)
+	}
+	return utils.Grouping(tgs), nil
+}
+
+// Config implements discovery.Configurer.
+func (impl *impl) Config() any {
+	return struct {
+		Address     string         `yaml:"address"`
+		Token       config.Secret  `yaml:"token,omitempty"`
+		Datacenter  string         `yaml:"datacenter,omitempty"`
+		Timeout     model.Duration `yaml:"timeout"`
+		Concurrency int            `yaml:"concurrency"`
+	}{
+		Address:     impl.o.address,
+		Token:       config.Secret(impl.o.token),
+		Datacenter:  impl.o.datacenter,
+		Timeout:     model.Duration(impl.o.timeout),
+		Concurrency: impl.o.concurrency,
+	}
+}
+
+// listServices returns the services having all the requested tags.
+func (impl *impl) listServices(ctx context.Context, q url.Values) ([]string, error) {
+	now := time.Now()
+	var catalog map[string][]string
+	if err := impl.get(ctx, "/v1/catalog/services", impl.params(q), &catalog); err != nil {
+		return nil, err
+	}
+	impl.duration.WithLabelValues("all").Observe(time.Since(now).Seconds())
+
+	var services []string
+	for service, tags := range catalog {
+		if hasTags(tags, q["tag"]) {
+			services = append(services, service)
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+func (impl *impl) getTargetgroupForService(ctx context.Context, service string, q url.Values) ([]*targetgroup.Group, error) {
+	now := time.Now()
+	params := impl.params(q)
+	for _, tag := range q["tag"] {
+		params.Add("tag", tag)
+	}
+	if passing, _ := strconv.ParseBool(q.Get("passing")); passing {
+		params.Set("passing", "true")
+	}
+	var entries []serviceEntry
+	if err := impl.get(ctx, "/v1/health/service/"+url.PathEscape(service), params, &entries); err != nil {
+		return nil, err
+	}
+	impl.duration.WithLabelValues(service).Observe(time.Since(now).Seconds())
+	return transform(entries), nil
+}
+
+// params returns the query parameters shared by catalog and health api.
+func (impl *impl) params(q url.Values) url.Values {
+	params := url.Values{}
+	dc := q.Get("dc")
+	if dc == "" {
+		dc = impl.o.datacenter
+	}
+	if dc != "" {
+		params.Set("dc", dc)
+	}
+	for _, meta := range q["node-meta"] {
+		params.Add("node-meta", meta)
+	}
+	return params
+}
+
+func (impl *impl) get(ctx context.Context, path string, params url.Values, v any) (err error) {
+	defer func() {
+		if err != nil {
+			impl.failures.WithLabelValues(discovery.KindOf(err)).Inc()
+		}
+	}()
+	u := impl.base.JoinPath(path)
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", discovery.UserAgent)
+	req.Header.Set("Accept", "application/json")
+	if impl.o.token != "" {
+		req.Header.Set("X-Consul-Token", impl.o.token)
+	}
+	resp, err := impl.client.Do(req)
+	if err != nil {
+		return discovery.UpstreamError(0, err)
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return discovery.UpstreamError(resp.StatusCode, fmt.Errorf("consul returned HTTP status %s", resp.Status))
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return discovery.NewError(discovery.KindInvalidResponse, err)
+	}
+	return nil
+}
+
+func hasTags(tags, required []string) bool {
+	for _, r := range required {
+		if !slices.Contains(tags, r) {
+			return false
+		}
+	}
+	return true
+}
+
+type node struct {
+	Node            string
+	Address         string
+	Datacenter      string
+	Partition       string
+	TaggedAddresses map[string]string
+	Meta            map[string]string
+}
+
+type service struct {
+	ID        string
+	Service   string
+	Tags      []string
+	Address   string
+	Port      int
+	Meta      map[string]string
+	Namespace string
+	Partition string
+}
+
+type check struct {
+	Status string
+}
+
+type serviceEntry struct {
+	Node    node
+	Service service
+	Checks  []check
+}
+
+// transform converts entries of consul health api into targetgroups, with
+// labels compatible with prometheus consul sd.
+func transform(entries []serviceEntry) []*targetgroup.Group {
+	var targetGroups []*targetgroup.Group
+	for _, entry := range entries {
+		addr := entry.Service.Address
+		if addr == "" {
+			addr = entry.Node.Address
+		}
+		g := &targetgroup.Group{
+			Targets: []model.LabelSet{
+				{model.AddressLabel: model.LabelValue(net.JoinHostPort(addr, strconv.Itoa(entry.Service.Port)))},
+			},
+			Labels: model.LabelSet{
+				labelName("address"):         model.LabelValue(entry.Node.Address),
+				labelName("dc"):              model.LabelValue(entry.Node.Datacenter),
+				labelName("health"):          model.LabelValue(aggregatedStatus(entry.Checks)),
+				labelName("node"):            model.LabelValue(entry.Node.Node),
+				labelName("service"):         model.LabelValue(entry.Service.Service),
+				labelName("service_address"): model.LabelValue(entry.Service.Address),
+				labelName("service_id"):      model.LabelValue(entry.Service.ID),
+				labelName("service_port"):    model.LabelValue(strconv.Itoa(entry.Service.Port)),
+			},
+		}
+		// tags are surrounded by separators to make regexp matching easier
+		g.Labels[labelName("tags")] = model.LabelValue(tagSeparator + strings.Join(entry.Service.Tags, tagSeparator) + tagSeparator)
+		if entry.Service.Namespace != "" {
+			g.Labels[labelName("namespace")] = model.LabelValue(entry.Service.Namespace)
+		}
+		if entry.Node.Partition != "" {
+			g.Labels[labelName("partition")] = model.LabelValue(entry.Node.Partition)
+		}
+		for k, v := range entry.Node.Meta {
+			g.Labels[labelName("metadata_"+k)] = model.LabelValue(v)
+		}
+		for k, v := range entry.Service.Meta {
+			g.Labels[labelName("service_metadata_"+k)] = model.LabelValue(v)
+		}
+		for k, v := range entry.Node.TaggedAddresses {
+			g.Labels[labelName("tagged_address_"+k)] = model.LabelValue(v)
+		}
+		targetGroups = append(targetGroups, g)
+	}
+	return targetGroups
+}
+
+// aggregatedStatus returns the worst status of checks, same as the one of
+// consul api client.
+func aggregatedStatus(checks []check) string {
+	var warning, critical bool
+	for _, c := range checks {
+		switch c.Status {
+		case "warning":
+			warning = true
+		case "critical":
+			critical = true
+		}
+	}
+	switch {
+	case critical:
+		return "critical"
+	case warning:
+		return "warning"
+	default:
+		return "passing"
+	}
+}
+
+func labelName(k string) model.LabelName {
+	return model.LabelName(fmt.Sprintf("%s%s_%s", model.MetaLabelPrefix, name, utils.FormalizeLabelName(k)))
+}
+
+func init() {
+	discovery.Register(name, &options{})
+}
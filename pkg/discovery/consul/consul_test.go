@@ -0,0 +1,204 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/fengxsong/httpsd/pkg/discovery"
+)
+
+// consulAPI is a stand-in of the catalog and health api of consul, it
+// records the query of every request by path.
+type consulAPI struct {
+	mu       sync.Mutex
+	requests map[string]url.Values
+}
+
+func (api *consulAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	api.requests[r.URL.Path] = r.URL.Query()
+	api.mu.Unlock()
+	if ua := r.Header.Get("User-Agent"); ua != discovery.UserAgent {
+		http.Error(w, "unexpected User-Agent "+ua, http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("X-Consul-Token") != "secret" {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+	var body any
+	switch r.URL.Path {
+	case "/v1/catalog/services":
+		body = map[string][]string{
+			"web":   {"prod", "http"},
+			"db":    {"prod"},
+			"cache": {"dev"},
+		}
+	case "/v1/health/service/web":
+		body = []map[string]any{{
+			"Node": map[string]any{
+				"Node":            "node-1",
+				"Address":         "10.0.0.1",
+				"Datacenter":      "dc2",
+				"TaggedAddresses": map[string]string{"lan": "10.0.0.1"},
+				"Meta":            map[string]string{"rack": "r1"},
+			},
+			"Service": map[string]any{
+				"ID":      "web-1",
+				"Service": "web",
+				"Tags":    []string{"prod", "http"},
+				"Port":    8080,
+				"Meta":    map[string]string{"version": "1.2"},
+			},
+			"Checks": []map[string]string{{"Status": "passing"}, {"Status": "warning"}},
+		}}
+	case "/v1/health/service/db":
+		body = []map[string]any{{
+			"Node": map[string]any{"Node": "node-2", "Address": "10.0.0.2", "Datacenter": "dc2"},
+			"Service": map[string]any{
+				"ID":      "db-1",
+				"Service": "db",
+				"Tags":    []string{"prod"},
+				"Address": "10.0.1.2",
+				"Port":    5432,
+			},
+			"Checks": []map[string]string{{"Status": "passing"}},
+		}}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func newTestDiscoverer(t *testing.T, api *consulAPI) *impl {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	o := &options{address: srv.URL, token: "secret", datacenter: "dc1", timeout: 5 * time.Second, concurrency: 2}
+	d, err := o.Build(log.NewNopLogger(), prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.(*impl)
+}
+
+func TestRefresh(t *testing.T) {
+	api := &consulAPI{requests: map[string]url.Values{}}
+	d := newTestDiscoverer(t, api)
+
+	q := url.Values{
+		"dc":        {"dc2"},
+		"tag":       {"prod"},
+		"node-meta": {"rack:r1"},
+		"passing":   {"true"},
+	}
+	tgs, err := d.Refresh(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCatalog := url.Values{"dc": {"dc2"}, "node-meta": {"rack:r1"}}
+	if got := api.requests["/v1/catalog/services"]; !reflect.DeepEqual(got, wantCatalog) {
+		t.Errorf("catalog query = %v, want %v", got, wantCatalog)
+	}
+	wantHealth := url.Values{"dc": {"dc2"}, "node-meta": {"rack:r1"}, "tag": {"prod"}, "passing": {"true"}}
+	for _, service := range []string{"web", "db"} {
+		if got := api.requests["/v1/health/service/"+service]; !reflect.DeepEqual(got, wantHealth) {
+			t.Errorf("health query of %s = %v, want %v", service, got, wantHealth)
+		}
+	}
+	if _, ok := api.requests["/v1/health/service/cache"]; ok {
+		t.Error("service without the requested tag was fetched")
+	}
+
+	var targets []model.LabelSet
+	for _, tg := range tgs {
+		for _, target := range tg.Targets {
+			targets = append(targets, tg.Labels.Merge(target))
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i][model.AddressLabel] < targets[j][model.AddressLabel] })
+	want := []model.LabelSet{
+		{
+			"__address__":                            "10.0.0.1:8080",
+			"__meta_consul_address":                  "10.0.0.1",
+			"__meta_consul_dc":                       "dc2",
+			"__meta_consul_health":                   "warning",
+			"__meta_consul_node":                     "node-1",
+			"__meta_consul_service":                  "web",
+			"__meta_consul_service_address":          "",
+			"__meta_consul_service_id":               "web-1",
+			"__meta_consul_service_port":             "8080",
+			"__meta_consul_tags":                     ",prod,http,",
+			"__meta_consul_metadata_rack":            "r1",
+			"__meta_consul_service_metadata_version": "1.2",
+			"__meta_consul_tagged_address_lan":       "10.0.0.1",
+		},
+		{
+			"__address__":                   "10.0.1.2:5432",
+			"__meta_consul_address":         "10.0.0.2",
+			"__meta_consul_dc":              "dc2",
+			"__meta_consul_health":          "passing",
+			"__meta_consul_node":            "node-2",
+			"__meta_consul_service":         "db",
+			"__meta_consul_service_address": "10.0.1.2",
+			"__meta_consul_service_id":      "db-1",
+			"__meta_consul_service_port":    "5432",
+			"__meta_consul_tags":            ",prod,",
+		},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("targets = %v, want %v", targets, want)
+	}
+}
+
+func TestRefreshServices(t *testing.T) {
+	api := &consulAPI{requests: map[string]url.Values{}}
+	d := newTestDiscoverer(t, api)
+
+	if _, err := d.Refresh(context.Background(), url.Values{"service": {"db"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := api.requests["/v1/catalog/services"]; ok {
+		t.Error("catalog listed although services are given")
+	}
+	// the default datacenter is used without `dc`
+	if got := api.requests["/v1/health/service/db"]; !reflect.DeepEqual(got, url.Values{"dc": {"dc1"}}) {
+		t.Errorf("health query = %v", got)
+	}
+}
+
+func TestRefreshError(t *testing.T) {
+	api := &consulAPI{requests: map[string]url.Values{}}
+	d := newTestDiscoverer(t, api)
+	d.o.token = "wrong"
+
+	_, err := d.Refresh(context.Background(), url.Values{})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("got error %v, want status 403", err)
+	}
+}
+
+func TestBuildConcurrency(t *testing.T) {
+	for _, concurrency := range []int{0, -1} {
+		o := &options{address: "http://localhost:8500", concurrency: concurrency}
+		if _, err := o.Build(log.NewNopLogger(), prometheus.NewRegistry()); err == nil {
+			t.Errorf("concurrency %d accepted", concurrency)
+		}
+	}
+}
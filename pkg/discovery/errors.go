@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Kinds of errors returned by discoverers, they're used as the `kind` label
+// of failure metrics as well.
+const (
+	// KindInvalidQuery is a query missing or having invalid parameters.
+	KindInvalidQuery = "invalid_query"
+	// KindNotFound is an unknown discoverer or source.
+	KindNotFound = "not_found"
+	// KindUpstream is a failed request to upstream, or a response of
+	// non-successful status.
+	KindUpstream = "upstream"
+	// KindTimeout is a request to upstream timed out.
+	KindTimeout = "timeout"
+	// KindInvalidResponse is a response of upstream failed to be parsed or
+	// transformed.
+	KindInvalidResponse = "invalid_response"
+	// KindInternal is any other error.
+	KindInternal = "internal"
+)
+
+// Error is an error of a known kind.
+type Error struct {
+	Kind string
+	// StatusCode is the HTTP status returned by upstream, zero if none.
+	StatusCode int
+	Err        error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func NewError(kind string, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// UpstreamError returns an error of KindUpstream, or KindTimeout if err is a
+// timeout.
+func UpstreamError(statusCode int, err error) *Error {
+	kind := KindUpstream
+	if isTimeout(err) {
+		kind = KindTimeout
+	}
+	return &Error{Kind: kind, StatusCode: statusCode, Err: err}
+}
+
+// KindOf returns the kind of err, errors of unknown kind are internal unless
+// they're timeouts.
+func KindOf(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	if isTimeout(err) {
+		return KindTimeout
+	}
+	return KindInternal
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
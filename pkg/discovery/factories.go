@@ -4,22 +4,73 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
 )
 
+// UserAgent is sent by discoverers requesting upstream over http.
+var UserAgent = fmt.Sprintf("HTTPServiceDiscoverer/%s", version.Version)
+
 type Discoverer interface {
 	Refresh(context.Context, url.Values) ([]*targetgroup.Group, error)
 }
 
+// Stopper is implemented by discoverers owning background goroutines or
+// clients, Stop is called once the discoverer is replaced on reload.
+type Stopper interface {
+	Stop()
+}
+
+// Status is the state of the background sync of a discoverer.
+type Status struct {
+	// Ready is true once the first sync has completed, which means upstream
+	// has been listed and not all of it failed to fetch.
+	Ready bool `json:"ready"`
+	// LastSync is the time of the last completed sync, which may have
+	// failed partially.
+	LastSync *time.Time `json:"lastSync,omitempty"`
+	// LastSuccess is the time of the last sync without any error.
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+// StatusReporter is implemented by discoverers syncing in background.
+type StatusReporter interface {
+	Status() Status
+}
+
+// Configurer is implemented by discoverers to show their configuration on
+// the status page, secrets must be redacted.
+type Configurer interface {
+	// Config returns a value to be marshaled into YAML.
+	Config() any
+}
+
 type Builder interface {
 	AddFlags(*kingpin.Application)
 	Build(log.Logger, prometheus.Registerer) (Discoverer, error)
 }
 
+// Reloadable is implemented by builders of discoverers configured by files,
+// which are rebuilt on reload if Reloadable returns true. Discoverers built
+// from command line flags only are kept running across reloads, so that
+// their caches stay warm.
+type Reloadable interface {
+	Reloadable() bool
+}
+
+// IsReloadable returns whether the discoverer of b has to be rebuilt on
+// reload.
+func IsReloadable(b Builder) bool {
+	r, ok := b.(Reloadable)
+	return ok && r.Reloadable()
+}
+
 var builers = make(map[string]Builder)
 
 func Register(name string, builder Builder) error {
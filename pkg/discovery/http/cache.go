@@ -0,0 +1,153 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/fengxsong/httpsd/pkg/utils"
+)
+
+// idleIntervals is the number of refresh intervals after which a query that
+// isn't requested anymore is evicted from cache.
+const idleIntervals = 10
+
+type cacheEntry struct {
+	q          url.Values
+	tgs        []*targetgroup.Group
+	err        error
+	updated    time.Time
+	lastAccess time.Time
+}
+
+// cache keeps the targetgroups of every distinct query of a source, they're
+// refreshed in background and evicted once not requested for a while.
+type cache struct {
+	interval     time.Duration
+	maxStaleness time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+func newCache(interval, maxStaleness time.Duration) *cache {
+	return &cache{
+		interval:     interval,
+		maxStaleness: maxStaleness,
+		entries:      map[string]*cacheEntry{},
+	}
+}
+
+// get returns the cached targetgroups of key, an error is returned instead
+// if the last refresh failed and the targetgroups are older than maxStaleness.
+func (c *cache) get(key string, now time.Time) ([]*targetgroup.Group, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e.lastAccess = now
+	if age := now.Sub(e.updated); e.err != nil && c.maxStaleness > 0 && age > c.maxStaleness {
+		return nil, true, fmt.Errorf("cached targetgroups are stale for %s: %w", age.Truncate(time.Second), e.err)
+	}
+	return e.tgs, true, nil
+}
+
+func (c *cache) set(key string, q url.Values, tgs []*targetgroup.Group, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &cacheEntry{q: q, tgs: tgs, updated: now, lastAccess: now}
+}
+
+// update records the result of a background refresh, the last good
+// targetgroups are kept on failure.
+func (c *cache) update(key string, tgs []*targetgroup.Group, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	e.err = err
+	if err == nil {
+		e.tgs = tgs
+		e.updated = now
+	}
+}
+
+// queries evicts idle entries and returns the queries to refresh.
+func (c *cache) queries(now time.Time) map[string]url.Values {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make(map[string]url.Values, len(c.entries))
+	for key, e := range c.entries {
+		if now.Sub(e.lastAccess) > idleIntervals*c.interval {
+			delete(c.entries, key)
+			continue
+		}
+		ret[key] = e.q
+	}
+	return ret
+}
+
+// staleness returns the age of the oldest cached targetgroups.
+func (c *cache) staleness(now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var oldest time.Duration
+	for _, e := range c.entries {
+		if age := now.Sub(e.updated); age > oldest {
+			oldest = age
+		}
+	}
+	return oldest
+}
+
+const (
+	// etagIdle is how long the targetgroups of a request which isn't made
+	// anymore are kept for conditional requests.
+	etagIdle = time.Hour
+	// maxEtags bounds the entries kept per source, the least recently used
+	// one is evicted first.
+	maxEtags = 1000
+)
+
+type etagEntry struct {
+	etag string
+	tgs  []*targetgroup.Group
+}
+
+// etags keeps the last targetgroups of every request along with the ETag
+// upstream sent, so unchanged payloads skip re-transformation. Entries are
+// evicted once idle or if there are too many, since requests are keyed by
+// their query.
+type etags struct {
+	mu sync.Mutex
+	m  *utils.IdleMap[etagEntry]
+}
+
+func (e *etags) get(key string, now time.Time) (etagEntry, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.m == nil {
+		return etagEntry{}, false
+	}
+	return e.m.Get(key, now)
+}
+
+func (e *etags) set(key, etag string, tgs []*targetgroup.Group, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.m == nil {
+		e.m = utils.NewIdleMap[etagEntry](etagIdle, maxEtags)
+	}
+	if etag == "" {
+		e.m.Delete(key)
+		return
+	}
+	e.m.Set(key, etagEntry{etag: etag, tgs: tgs}, now)
+}
@@ -0,0 +1,175 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// fakeUpstream serves one target per `app` parameter, labeled by version,
+// and fails once failing is set.
+type fakeUpstream struct {
+	version  atomic.Int32
+	failing  atomic.Bool
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (u *fakeUpstream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	app := req.URL.Query().Get("app")
+	u.mu.Lock()
+	u.requests[app]++
+	u.mu.Unlock()
+	if u.failing.Load() {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `[{"targets":["%s:80"],"labels":{"version":"%d"}}]`, app, u.version.Load())
+}
+
+func (u *fakeUpstream) requested(app string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.requests[app]
+}
+
+func metricValue(t *testing.T, c prometheus.Collector) float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 1)
+	c.Collect(ch)
+	var m dto.Metric
+	if err := (<-ch).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Counter != nil {
+		return m.Counter.GetValue()
+	}
+	return m.Gauge.GetValue()
+}
+
+func TestCacheRefresh(t *testing.T) {
+	upstream := &fakeUpstream{requests: map[string]int{}}
+	ts := httptest.NewServer(upstream)
+	defer ts.Close()
+
+	const interval = time.Hour
+	s, err := newSource(&SDConfig{
+		Name:            "a",
+		Type:            "asitis",
+		URL:             ts.URL,
+		RefreshInterval: model.Duration(interval),
+		MaxStaleness:    model.Duration(2 * interval),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Discovery{
+		sources:       map[string]*source{"a": s},
+		defaultSource: "a",
+		metrics:       newDiscovererMetrics(prometheus.NewRegistry()).(*httpMetrics),
+		logger:        log.NewNopLogger(),
+	}
+	base := time.Now()
+	at := func(d time.Duration) func() time.Time {
+		return func() time.Time { return base.Add(d) }
+	}
+	refresh := func(app string) model.LabelValue {
+		t.Helper()
+		tgs, err := d.Refresh(context.Background(), url.Values{"app": {app}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tgs) != 1 {
+			t.Fatalf("got %d targetgroups, want 1", len(tgs))
+		}
+		return tgs[0].Labels["version"]
+	}
+
+	refresh("x")
+	refresh("x")
+	refresh("y")
+	if got := upstream.requested("x"); got != 1 {
+		t.Errorf("upstream requested %d times for cached query, want 1", got)
+	}
+	if hits, misses := metricValue(t, d.metrics.cacheHits), metricValue(t, d.metrics.cacheMisses); hits != 1 || misses != 2 {
+		t.Errorf("got %v hits and %v misses, want 1 and 2", hits, misses)
+	}
+
+	// every cached query is refreshed in background
+	upstream.version.Store(1)
+	d.refreshCache(context.Background(), s, at(interval))
+	if got := refresh("x"); got != "1" {
+		t.Errorf("got version %s after background refresh, want 1", got)
+	}
+	if got := upstream.requested("y"); got != 2 {
+		t.Errorf("upstream requested %d times for y, want 2", got)
+	}
+
+	// the last good targetgroups are served while upstream fails
+	upstream.failing.Store(true)
+	d.refreshCache(context.Background(), s, at(2*interval))
+	if got := refresh("x"); got != "1" {
+		t.Errorf("got version %s while upstream fails, want 1", got)
+	}
+	if got := metricValue(t, d.metrics.cacheStaleness); got != interval.Seconds() {
+		t.Errorf("staleness = %vs, want %vs", got, interval.Seconds())
+	}
+	// until they're older than max staleness
+	if _, _, err := s.cache.get("app=x", base.Add(3*interval+time.Second)); err == nil {
+		t.Error("stale targetgroups served without error")
+	}
+	if _, _, err := s.cache.get("app=x", base.Add(3*interval)); err != nil {
+		t.Errorf("targetgroups within max staleness: %v", err)
+	}
+
+	// queries not requested for 10 intervals are evicted, x was requested
+	// at 3 intervals above
+	requested := upstream.requested("x")
+	d.refreshCache(context.Background(), s, at(11*interval))
+	if got := upstream.requested("y"); got != 3 {
+		t.Errorf("upstream requested %d times for idle y, want 3", got)
+	}
+	if got := upstream.requested("x"); got != requested+1 {
+		t.Errorf("upstream requested %d times for x, want %d", got, requested+1)
+	}
+	if got := s.cache.queries(base.Add(11 * interval)); len(got) != 1 || got["app=x"] == nil {
+		t.Errorf("cached queries = %v, want app=x only", got)
+	}
+}
+
+func TestEtagsBounded(t *testing.T) {
+	var e etags
+	now := time.Now()
+	for i := 0; i <= maxEtags; i++ {
+		e.set(fmt.Sprint(i), "1", nil, now)
+	}
+	if got := e.m.Len(); got != maxEtags {
+		t.Fatalf("got %d entries, want %d", got, maxEtags)
+	}
+	e.set("new", "1", nil, now.Add(etagIdle+2*time.Minute))
+	if got := e.m.Len(); got != 1 {
+		t.Fatalf("got %d entries after idle sweep, want 1", got)
+	}
+}
+
+func TestEtagsSetWithoutEtag(t *testing.T) {
+	var e etags
+	now := time.Now()
+	e.set("a", "1", nil, now)
+	e.set("a", "", nil, now)
+	if _, ok := e.get("a", now); ok {
+		t.Error("entry kept without etag")
+	}
+}
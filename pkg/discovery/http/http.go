@@ -14,10 +14,12 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
@@ -27,20 +29,24 @@ import (
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/grafana/regexp"
 	"github.com/mitchellh/mapstructure"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
-	"github.com/prometheus/common/version"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/relabel"
+	"golang.org/x/sync/singleflight"
 	"gopkg.in/yaml.v2"
 
+	"github.com/fengxsong/httpsd/pkg/decoder"
 	"github.com/fengxsong/httpsd/pkg/discovery"
 	"github.com/fengxsong/httpsd/pkg/transformer"
 	"github.com/fengxsong/httpsd/pkg/utils"
 
 	_ "github.com/fengxsong/httpsd/pkg/transformer/asitis"
+	_ "github.com/fengxsong/httpsd/pkg/transformer/eureka"
 	_ "github.com/fengxsong/httpsd/pkg/transformer/nacos"
 )
 
@@ -50,7 +56,6 @@ var (
 		Timeout:          model.Duration(60 * time.Second),
 		HTTPClientConfig: config.DefaultHTTPClientConfig,
 	}
-	userAgent        = fmt.Sprintf("HTTPServiceDiscoverer/%s", version.Version)
 	matchContentType = regexp.MustCompile(`^(?i:application\/json(;\s*charset=("utf-8"|utf-8))?)$`)
 )
 
@@ -59,8 +64,28 @@ type SDConfig struct {
 	HTTPClientConfig config.HTTPClientConfig `yaml:",inline" mapstructure:",squash"`
 	Timeout          model.Duration          `yaml:"timeout,omitempty"`
 
+	// RefreshInterval enables the cache of targetgroups, which are refreshed
+	// in background per distinct query.
+	RefreshInterval model.Duration `yaml:"refresh_interval,omitempty"`
+	// MaxStaleness limits how long the cached targetgroups are served after
+	// upstream starts failing, zero means no limit.
+	MaxStaleness model.Duration `yaml:"max_staleness,omitempty"`
+
+	Name     string               `yaml:"name,omitempty"`
+	Type     string               `yaml:"type,omitempty"`
 	URL      string               `yaml:"url"`
 	Template transformer.Template `yaml:",inline" mapstructure:",squash"`
+	Request  transformer.Request  `yaml:",inline" mapstructure:",squash"`
+
+	// ResponseFormat is the name of the decoder of responses, which is
+	// selected by their Content-Type if empty.
+	ResponseFormat string `yaml:"response_format,omitempty"`
+
+	// Pagination requests all pages of upstream if set.
+	Pagination *Pagination `yaml:"pagination,omitempty"`
+
+	// RelabelConfigs are applied to every target after transformation.
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs,omitempty"`
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
@@ -71,6 +96,13 @@ func (c *SDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 }
 
 func (c *SDConfig) Validate() error {
+	if err := c.validateURL(); err != nil {
+		return err
+	}
+	return c.HTTPClientConfig.Validate()
+}
+
+func (c *SDConfig) validateURL() error {
 	if c.URL == "" {
 		return fmt.Errorf("URL is missing")
 	}
@@ -84,45 +116,188 @@ func (c *SDConfig) Validate() error {
 	if parsedURL.Host == "" {
 		return fmt.Errorf("host is missing in URL")
 	}
-	return c.HTTPClientConfig.Validate()
+	return nil
+}
+
+// Config is the content of the config file, it holds either a list of named
+// sources or, for backward compatibility, one single SDConfig.
+type Config struct {
+	Sources []*SDConfig `yaml:"sources"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var probe map[string]any
+	if err := unmarshal(&probe); err != nil {
+		return err
+	}
+	if _, ok := probe["sources"]; ok {
+		type plain Config
+		return unmarshal((*plain)(c))
+	}
+	sc := &SDConfig{}
+	if err := unmarshal(sc); err != nil {
+		return err
+	}
+	c.Sources = []*SDConfig{sc}
+	return nil
+}
+
+// SourceError reports an invalid source of the config file.
+type SourceError struct {
+	// Index of the source in the config file.
+	Index int
+	Name  string
+	// Field is the yaml key at fault, empty if it's the source as a whole.
+	Field string
+	Err   error
+}
+
+func (e *SourceError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("source at index %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("source %s: %v", e.Name, e.Err)
 }
 
+func (e *SourceError) Unwrap() error { return e.Err }
+
+// Validate sets the defaults of all sources and checks them, every invalid
+// source is reported as a *SourceError in the joined error.
+func (c *Config) Validate(defaultType string) error {
+	if len(c.Sources) == 0 {
+		return errors.New("no source configured")
+	}
+	var errs []error
+	seen := make(map[string]struct{}, len(c.Sources))
+	for i, sc := range c.Sources {
+		if sc == nil {
+			errs = append(errs, &SourceError{Index: i, Err: errors.New("empty source")})
+			continue
+		}
+		if sc.Name == "" && len(c.Sources) == 1 {
+			sc.Name = defaultSourceName
+		}
+		// the other fields are checked even if the name is invalid
+		if sc.Name == "" {
+			errs = append(errs, &SourceError{Index: i, Field: "name", Err: errors.New("name is missing")})
+		} else if _, ok := seen[sc.Name]; ok {
+			errs = append(errs, &SourceError{Index: i, Name: sc.Name, Field: "name", Err: errors.New("duplicated name")})
+		}
+		seen[sc.Name] = struct{}{}
+		if sc.Type == "" {
+			sc.Type = defaultType
+		}
+		if err := sc.validateURL(); err != nil {
+			errs = append(errs, &SourceError{Index: i, Name: sc.Name, Field: "url", Err: err})
+		}
+		if err := sc.HTTPClientConfig.Validate(); err != nil {
+			errs = append(errs, &SourceError{Index: i, Name: sc.Name, Err: err})
+		}
+		if err := sc.Request.Validate(); err != nil {
+			field := "body"
+			if errors.Is(err, transformer.ErrInvalidMethod) {
+				field = "method"
+			}
+			errs = append(errs, &SourceError{Index: i, Name: sc.Name, Field: field, Err: err})
+		}
+		if sc.ResponseFormat != "" && decoder.Get(sc.ResponseFormat) == nil {
+			errs = append(errs, &SourceError{Index: i, Name: sc.Name, Field: "response_format",
+				Err: fmt.Errorf("unknown response format %q, must be one of %s", sc.ResponseFormat, strings.Join(decoder.Names(), ", "))})
+		}
+		if sc.Pagination != nil {
+			if err := sc.Pagination.Validate(); err != nil {
+				errs = append(errs, &SourceError{Index: i, Name: sc.Name, Field: "pagination", Err: err})
+			}
+		}
+		if sc.RefreshInterval < 0 {
+			errs = append(errs, &SourceError{Index: i, Name: sc.Name, Field: "refresh_interval", Err: errors.New("refresh_interval must not be negative")})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseConfig parses the content of a config file.
+func ParseConfig(content []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadConfig reads and parses the config file at path.
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, sc := range cfg.Sources {
+		if sc != nil {
+			sc.HTTPClientConfig.SetDirectory(filepath.Dir(filepath.Dir(path)))
+		}
+	}
+	return cfg, nil
+}
+
+const defaultSourceName = "default"
+
 type options struct {
-	configPath string
-	url        string
-	ttype      string
-	username   string
-	password   string
+	configPath      string
+	url             string
+	ttype           string
+	username        string
+	password        string
+	refreshInterval time.Duration
+	maxStaleness    time.Duration
+
+	// metrics are shared by all discoverers built on reload
+	metrics *httpMetrics
 }
 
 func (o *options) AddFlags(app *kingpin.Application) {
 	app.Flag("http.config", "path of config file").Default("").StringVar(&o.configPath)
 	app.Flag("http.url", "url to fetch and convert into targetgroups").Default("").StringVar(&o.url)
-	app.Flag("http.type", "transformer type").Default("asitis").StringVar(&o.ttype)
+	app.Flag("http.type", "default transformer type").Default("asitis").StringVar(&o.ttype)
 	app.Flag("http.basic-auth.username", "username for basic HTTP authentication").Short('u').Default("").StringVar(&o.username)
 	app.Flag("http.basic-auth.password", "password for basic HTTP authentication").Short('p').Default("").StringVar(&o.password)
+	app.Flag("http.refresh-interval", "interval of refreshing cached targetgroups in background, 0 disables the cache").Default("0s").DurationVar(&o.refreshInterval)
+	app.Flag("http.max-staleness", "max age of cached targetgroups served when upstream is failing, 0 means no limit").Default("0s").DurationVar(&o.maxStaleness)
 }
 
-func (o *options) Build(logger log.Logger, registerer prometheus.Registerer) (discovery.Discoverer, error) {
+func (o *options) config() (*Config, error) {
 	if o.configPath != "" {
-		content, err := os.ReadFile(o.configPath)
-		if err != nil {
-			return nil, err
-		}
-		if err = yaml.UnmarshalStrict(content, &DefaultSDConfig); err != nil {
-			return nil, err
-		}
-		DefaultSDConfig.HTTPClientConfig.SetDirectory(filepath.Dir(filepath.Dir(o.configPath)))
-	} else {
-		DefaultSDConfig.URL = o.url
-		if o.username != "" && o.password != "" {
-			DefaultSDConfig.HTTPClientConfig.BasicAuth = &config.BasicAuth{
-				Username: o.username,
-				Password: config.Secret(o.password),
-			}
+		return LoadConfig(o.configPath)
+	}
+	sc := DefaultSDConfig
+	sc.URL = o.url
+	sc.RefreshInterval = model.Duration(o.refreshInterval)
+	sc.MaxStaleness = model.Duration(o.maxStaleness)
+	if o.username != "" && o.password != "" {
+		sc.HTTPClientConfig.BasicAuth = &config.BasicAuth{
+			Username: o.username,
+			Password: config.Secret(o.password),
 		}
 	}
-	if err := DefaultSDConfig.Validate(); err != nil {
+	return &Config{Sources: []*SDConfig{&sc}}, nil
+}
+
+// Reloadable implements discovery.Reloadable, the config file may have
+// changed since the last build.
+func (o *options) Reloadable() bool {
+	return o.configPath != ""
+}
+
+func (o *options) Build(logger log.Logger, registerer prometheus.Registerer) (discovery.Discoverer, error) {
+	cfg, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.Validate(o.ttype); err != nil {
 		return nil, err
 	}
 
@@ -130,111 +305,381 @@ func (o *options) Build(logger log.Logger, registerer prometheus.Registerer) (di
 		logger = log.NewNopLogger()
 	}
 
-	tr := transformer.Get(o.ttype)
+	if o.metrics == nil {
+		m := newDiscovererMetrics(registerer)
+		if err = m.Register(); err != nil {
+			return nil, err
+		}
+		o.metrics = m.(*httpMetrics)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Discovery{
+		sources:       make(map[string]*source, len(cfg.Sources)),
+		cfg:           cfg,
+		defaultSource: cfg.Sources[0].Name,
+		metrics:       o.metrics,
+		logger:        logger,
+		cancel:        cancel,
+	}
+	for _, sc := range cfg.Sources {
+		s, err := newSource(sc)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("source %s: %w", sc.Name, err)
+		}
+		d.sources[sc.Name] = s
+	}
+	for _, s := range d.sources {
+		if s.cache != nil {
+			go d.run(ctx, s)
+		}
+	}
+
+	return d, nil
+}
+
+// NewTransformer returns the initialized transformer of the given source config.
+func NewTransformer(sc *SDConfig) (transformer.Transformer, error) {
+	tr := transformer.Get(sc.Type)
 	if tr == nil {
-		return nil, fmt.Errorf("unknown transformer %s", o.ttype)
+		return nil, fmt.Errorf("unknown transformer %s", sc.Type)
 	}
 	if sampleConfig := tr.SampleConfig(); sampleConfig != nil {
-		if err := mapstructure.Decode(&DefaultSDConfig, sampleConfig); err != nil {
+		if err := mapstructure.Decode(sc, sampleConfig); err != nil {
 			return nil, err
 		}
 		if err := tr.Init(sampleConfig); err != nil {
 			return nil, err
 		}
 	}
+	return tr, nil
+}
 
-	m := newDiscovererMetrics(registerer)
-	m.Register()
+type source struct {
+	name           string
+	url            string
+	client         *http.Client
+	tr             transformer.Transformer
+	relabelConfigs []*relabel.Config
+	pagination     *Pagination
+	request        transformer.Request
+	// decoder is nil if it's selected by the Content-Type of responses
+	decoder decoder.Decoder
+	// cache is nil unless refresh interval is set
+	cache *cache
+	group singleflight.Group
+	etags etags
+}
 
-	client, err := config.NewClientFromConfig(DefaultSDConfig.HTTPClientConfig, "http", config.WithUserAgent(userAgent))
+func newSource(sc *SDConfig) (*source, error) {
+	tr, err := NewTransformer(sc)
+	if err != nil {
+		return nil, err
+	}
+	client, err := config.NewClientFromConfig(sc.HTTPClientConfig, "http", config.WithUserAgent(discovery.UserAgent))
 	if err != nil {
 		return nil, err
 	}
-	client.Timeout = time.Duration(DefaultSDConfig.Timeout)
+	client.Timeout = time.Duration(sc.Timeout)
+	s := &source{
+		name:           sc.Name,
+		url:            sc.URL,
+		client:         client,
+		tr:             tr,
+		relabelConfigs: sc.RelabelConfigs,
+		pagination:     sc.Pagination,
+		request:        sc.Request,
+		decoder:        decoder.Get(sc.ResponseFormat),
+	}
+	if sc.RefreshInterval > 0 {
+		s.cache = newCache(time.Duration(sc.RefreshInterval), time.Duration(sc.MaxStaleness))
+	}
+	return s, nil
+}
 
-	d := &Discovery{
-		url:     DefaultSDConfig.URL,
-		client:  client,
-		metrics: m.(*httpMetrics),
-		tr:      tr,
-		logger:  logger,
+// body returns the request body of the query, the configured one takes
+// precedence over the one of the transformer.
+func (s *source) body(q url.Values) ([]byte, error) {
+	if s.request.Body != "" {
+		return s.request.HTTPBody(q)
 	}
+	return s.tr.HTTPBody(q)
+}
 
-	return d, nil
+// decode returns the response body as JSON, unless the transformer accepts
+// its content type as it is.
+func (s *source) decode(contentType string, b []byte) ([]byte, error) {
+	dec := s.decoder
+	if dec == nil {
+		if m, ok := s.tr.(transformer.ContentTypeMatcher); ok && m.MatchContentType(contentType) {
+			return b, nil
+		}
+		if matchContentType.MatchString(contentType) {
+			return b, nil
+		}
+		if dec = decoder.ForContentType(contentType); dec == nil {
+			return nil, fmt.Errorf("unsupported content type %q", contentType)
+		}
+	}
+	return decoder.ToJSON(dec, b)
 }
 
 // Discovery provides service discovery functionality based
 // on HTTP endpoints that return target groups in JSON format.
 type Discovery struct {
-	url     string
-	client  *http.Client
-	metrics *httpMetrics
-	tr      transformer.Transformer
-	logger  log.Logger
+	cfg           *Config
+	sources       map[string]*source
+	defaultSource string
+	metrics       *httpMetrics
+	logger        log.Logger
+	cancel        context.CancelFunc
+}
+
+// Stop implements discovery.Stopper.
+func (d *Discovery) Stop() {
+	d.cancel()
+}
+
+// Config implements discovery.Configurer, secrets are redacted when
+// marshaled.
+func (d *Discovery) Config() any {
+	return d.cfg
 }
 
+// Refresh fetches the targetgroups of the source selected by the `source`
+// query parameter, the first configured source is used if it's absent.
 func (d *Discovery) Refresh(ctx context.Context, q url.Values) ([]*targetgroup.Group, error) {
-	start := time.Now()
-	targetUrl, err := d.tr.TargetURL(d.url, q)
+	name := q.Get("source")
+	if name == "" {
+		name = d.defaultSource
+	}
+	s, ok := d.sources[name]
+	if !ok {
+		return nil, discovery.NewError(discovery.KindNotFound, fmt.Errorf("unknown source %s", name))
+	}
+	q = maps.Clone(q)
+	q.Del("source")
+	if s.cache == nil {
+		return d.fetch(ctx, s, q)
+	}
+
+	key := q.Encode()
+	now := time.Now()
+	tgs, ok, err := s.cache.get(key, now)
+	if ok {
+		d.metrics.cacheHits.WithLabelValues(s.name).Inc()
+		return tgs, err
+	}
+	d.metrics.cacheMisses.WithLabelValues(s.name).Inc()
+	tgs, err = d.fetch(ctx, s, q)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(d.tr.HTTPMethod(), targetUrl, nil)
+	s.cache.set(key, q, tgs, now)
+	return tgs, nil
+}
+
+// run refreshes the cached targetgroups of source s until ctx is done.
+func (d *Discovery) run(ctx context.Context, s *source) {
+	ticker := time.NewTicker(s.cache.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.refreshCache(ctx, s, time.Now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshCache refreshes every query cached by source s once, idle ones are
+// evicted instead.
+func (d *Discovery) refreshCache(ctx context.Context, s *source, now func() time.Time) {
+	for key, q := range s.cache.queries(now()) {
+		tgs, err := d.fetch(ctx, s, q)
+		if err != nil {
+			level.Warn(d.logger).Log("msg", "failed to refresh cached targetgroups", "source", s.name, "query", key, "err", err)
+		}
+		s.cache.update(key, tgs, err, now())
+	}
+	d.metrics.cacheStaleness.WithLabelValues(s.name).Set(s.cache.staleness(now()).Seconds())
+}
+
+// fetch requests upstream and transforms the response, concurrent callers
+// resolving to the same target url share one request.
+func (d *Discovery) fetch(ctx context.Context, s *source, q url.Values) ([]*targetgroup.Group, error) {
+	targetUrl, err := s.tr.TargetURL(s.url, q)
+	if err != nil {
+		return nil, discovery.NewError(discovery.KindInvalidQuery, err)
+	}
+	// transformers may filter on parameters which aren't part of the target
+	// url, so they're part of the key as well
+	key := targetUrl + " " + q.Encode()
+	coalesced := true
+	v, err, _ := s.group.Do(key, func() (any, error) {
+		coalesced = false
+		// the shared request must not be canceled along with the first caller
+		return d.do(transformer.WithQuery(context.WithoutCancel(ctx), q), s, key, targetUrl)
+	})
+	if coalesced {
+		d.metrics.coalescedCount.WithLabelValues(s.name).Inc()
+	}
 	if err != nil {
 		return nil, err
 	}
+	return v.([]*targetgroup.Group), nil
+}
+
+func (d *Discovery) do(ctx context.Context, s *source, key, targetUrl string) (_ []*targetgroup.Group, err error) {
+	defer func() {
+		if err != nil {
+			d.metrics.failuresCount.WithLabelValues(s.name, discovery.KindOf(err)).Inc()
+		}
+	}()
+
+	body, err := s.body(transformer.QueryFromContext(ctx))
+	if err != nil {
+		return nil, discovery.NewError(discovery.KindInvalidQuery, err)
+	}
+	var (
+		b    []byte
+		etag string
+	)
+	if s.pagination != nil {
+		// pages are merged, so conditional requests aren't supported
+		if b, err = d.getPages(ctx, s, targetUrl, body); err != nil {
+			return nil, err
+		}
+	} else {
+		last, conditional := s.etags.get(key, time.Now())
+		var resp *response
+		if resp, err = d.get(ctx, s, targetUrl, body, last.etag); err != nil {
+			return nil, err
+		}
+		if conditional && resp.notModified {
+			return last.tgs, nil
+		}
+		b, etag = resp.body, resp.header.Get("ETag")
+	}
+
+	targetGroups, err := s.tr.Transform(ctx, b)
+	if err != nil {
+		if errors.Is(err, transformer.ErrInvalidQuery) {
+			return nil, discovery.NewError(discovery.KindInvalidQuery, err)
+		}
+		return nil, discovery.NewError(discovery.KindInvalidResponse, err)
+	}
+
+	for _, tg := range targetGroups {
+		if tg == nil {
+			return nil, discovery.NewError(discovery.KindInvalidResponse, errors.New("nil target group item found"))
+		}
+	}
+	targetGroups = utils.Grouping(utils.Relabel(targetGroups, s.relabelConfigs...))
+	for i, tg := range targetGroups {
+		tg.Source = urlSource(s.url, i)
+		if tg.Labels == nil {
+			tg.Labels = model.LabelSet{}
+		}
+	}
+
+	s.etags.set(key, etag, targetGroups, time.Now())
+	return targetGroups, nil
+}
 
-	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Accept", "application/json")
+type response struct {
+	body   []byte
+	header http.Header
+	// notModified is true if upstream responded 304 to If-None-Match
+	notModified bool
+}
 
-	resp, err := d.client.Do(req.WithContext(ctx))
-	d.metrics.discoverDuration.Observe(time.Since(start).Seconds())
+// get requests one url of upstream, the ETag of the last response is sent
+// in If-None-Match if any.
+func (d *Discovery) get(ctx context.Context, s *source, targetUrl string, body []byte, etag string) (*response, error) {
+	start := time.Now()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, s.request.HTTPMethod(s.tr.HTTPMethod()), targetUrl, reader)
 	if err != nil {
-		d.metrics.failuresCount.Inc()
 		return nil, err
 	}
+	req.Header.Set("User-Agent", discovery.UserAgent)
+	if s.decoder != nil {
+		req.Header.Set("Accept", s.decoder.ContentTypes()[0])
+	} else {
+		req.Header.Set("Accept", decoder.Accept())
+	}
+	if body != nil {
+		contentType := s.request.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := s.client.Do(req)
+	d.metrics.discoverDuration.WithLabelValues(s.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, discovery.UpstreamError(0, err)
+	}
 	defer func() {
 		io.Copy(io.Discard, resp.Body)
 		resp.Body.Close()
 	}()
 
-	if resp.StatusCode != http.StatusOK {
-		d.metrics.failuresCount.Inc()
-		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	if etag != "" && resp.StatusCode == http.StatusNotModified {
+		return &response{header: resp.Header, notModified: true}, nil
 	}
 
-	if !matchContentType.MatchString(strings.TrimSpace(resp.Header.Get("Content-Type"))) {
-		d.metrics.failuresCount.Inc()
-		return nil, fmt.Errorf("unsupported content type %q", resp.Header.Get("Content-Type"))
+	if resp.StatusCode != http.StatusOK {
+		return nil, discovery.UpstreamError(resp.StatusCode, fmt.Errorf("server returned HTTP status %s", resp.Status))
 	}
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		d.metrics.failuresCount.Inc()
-		return nil, err
+		return nil, discovery.UpstreamError(0, err)
+	}
+	if b, err = s.decode(strings.TrimSpace(resp.Header.Get("Content-Type")), b); err != nil {
+		return nil, discovery.NewError(discovery.KindInvalidResponse, err)
 	}
+	return &response{body: b, header: resp.Header}, nil
+}
 
-	targetGroups, err := d.tr.Transform(ctx, b)
+// getPages requests all pages of upstream and merges them into one body.
+func (d *Discovery) getPages(ctx context.Context, s *source, targetUrl string, body []byte) ([]byte, error) {
+	pg, err := newPaginator(s.pagination)
 	if err != nil {
-		d.metrics.failuresCount.Inc()
 		return nil, err
 	}
-
-	targetGroups = utils.Grouping(targetGroups)
-	for i, tg := range targetGroups {
-		if tg == nil {
-			d.metrics.failuresCount.Inc()
-			err = errors.New("nil target group item found")
+	next, err := pg.firstURL(targetUrl)
+	if err != nil {
+		return nil, err
+	}
+	for next != "" {
+		current := next
+		resp, err := d.get(ctx, s, current, body, "")
+		if err != nil {
 			return nil, err
 		}
-
-		tg.Source = urlSource(d.url, i)
-		if tg.Labels == nil {
-			tg.Labels = model.LabelSet{}
+		next, err = pg.add(current, resp.body, strings.Join(resp.header.Values("Link"), ","))
+		if err != nil {
+			return nil, discovery.NewError(discovery.KindInvalidResponse, err)
 		}
 	}
-
-	return targetGroups, nil
+	b, err := pg.merged()
+	if err != nil {
+		return nil, discovery.NewError(discovery.KindInvalidResponse, err)
+	}
+	return b, nil
 }
 
 // urlSource returns a source ID for the i-th target group per URL.
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/fengxsong/httpsd/pkg/decoder"
+)
+
+func TestConfigRedactsSecrets(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+sources:
+  - name: cmdb
+    url: https://cmdb.example.com/api/hosts
+    basic_auth:
+      username: foo
+      password: SUPERSECRET
+    http_headers:
+      Authorization-Token:
+        secrets: [SUPERSECRET]
+      X-Tenant:
+        values: [ops]
+    body: '{"env": "{{ .env }}"}'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Validate("asitis"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := yaml.Marshal((&Discovery{cfg: cfg}).Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "SUPERSECRET") {
+		t.Errorf("secret shown in config:\n%s", b)
+	}
+	for _, want := range []string{"ops", "body:"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("%q missing in config:\n%s", want, b)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		errs   []string
+	}{
+		{
+			name:   "valid",
+			config: "sources:\n  - name: a\n    url: http://a\n  - name: b\n    url: http://b\n",
+		},
+		{
+			name:   "single source without name",
+			config: "url: http://a\n",
+		},
+		{
+			name:   "errors after a missing name are reported",
+			config: "sources:\n  - url: http://a\n    response_format: toml\n  - name: b\n    url: ftp://b\n",
+			errs:   []string{"name is missing", "unknown response format", "scheme"},
+		},
+		{
+			name:   "duplicated name",
+			config: "sources:\n  - name: a\n    url: http://a\n  - name: a\n    url: http://b\n",
+			errs:   []string{"duplicated name"},
+		},
+		{
+			name:   "invalid method and body",
+			config: "sources:\n  - name: a\n    url: http://a\n    method: GE T\n    body: '{{ .env '\n",
+			errs:   []string{"invalid method"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(tc.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.Validate("asitis")
+			if len(tc.errs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no error, want %q", tc.errs)
+			}
+			for _, want := range tc.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSourceDecode(t *testing.T) {
+	const xmlBody = `<hosts><host>10.0.0.1</host></hosts>`
+	for _, tc := range []struct {
+		name           string
+		ttype          string
+		responseFormat string
+		contentType    string
+		body           string
+		want           string
+		err            bool
+	}{
+		{name: "json", ttype: "asitis", contentType: "application/json", body: `{"a":1}`, want: `{"a":1}`},
+		{name: "xml by content type", ttype: "asitis", contentType: "text/xml", body: xmlBody, want: `{"hosts":{"host":"10.0.0.1"}}`},
+		{name: "unsupported content type", ttype: "asitis", contentType: "text/plain", body: "ip\n10.0.0.1\n", err: true},
+		{name: "response format overrides content type", ttype: "asitis", responseFormat: "csv", contentType: "text/plain", body: "ip\n10.0.0.1\n", want: `{"records":[{"ip":"10.0.0.1"}]}`},
+		{name: "raw body for content type matchers", ttype: "eureka", contentType: "application/xml", body: xmlBody, want: xmlBody},
+		{name: "response format overrides content type matchers", ttype: "eureka", responseFormat: "xml", contentType: "application/xml", body: xmlBody, want: `{"hosts":{"host":"10.0.0.1"}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := newSource(&SDConfig{Name: "a", Type: tc.ttype, URL: "http://a", ResponseFormat: tc.responseFormat})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := s.decode(tc.contentType, []byte(tc.body))
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v, want error %t", err, tc.err)
+			}
+			if string(b) != tc.want {
+				t.Errorf("got %s, want %s", b, tc.want)
+			}
+		})
+	}
+}
+
+func TestRequestAccept(t *testing.T) {
+	var accept string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		accept = req.Header.Get("Accept")
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write([]byte("- targets: [a:80]\n"))
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		responseFormat string
+		want           string
+	}{
+		{want: decoder.Accept()},
+		{responseFormat: "yaml", want: "application/yaml"},
+	} {
+		s, err := newSource(&SDConfig{Name: "a", Type: "asitis", URL: ts.URL, ResponseFormat: tc.responseFormat})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := &Discovery{
+			sources:       map[string]*source{"a": s},
+			defaultSource: "a",
+			metrics:       newDiscovererMetrics(prometheus.NewRegistry()).(*httpMetrics),
+			logger:        log.NewNopLogger(),
+		}
+		tgs, err := d.Refresh(context.Background(), url.Values{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tgs) != 1 || tgs[0].Targets[0]["__address__"] != "a:80" {
+			t.Errorf("response_format %q: got targetgroups %v", tc.responseFormat, tgs)
+		}
+		if accept != tc.want {
+			t.Errorf("response_format %q: Accept = %q, want %q", tc.responseFormat, accept, tc.want)
+		}
+	}
+}
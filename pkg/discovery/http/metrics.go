@@ -22,8 +22,12 @@ import (
 var _ discovery.DiscovererMetrics = (*httpMetrics)(nil)
 
 type httpMetrics struct {
-	failuresCount    prometheus.Counter
-	discoverDuration prometheus.Histogram
+	failuresCount    *prometheus.CounterVec
+	discoverDuration *prometheus.HistogramVec
+	cacheHits        *prometheus.CounterVec
+	cacheMisses      *prometheus.CounterVec
+	cacheStaleness   *prometheus.GaugeVec
+	coalescedCount   *prometheus.CounterVec
 
 	metricRegisterer discovery.MetricRegisterer
 }
@@ -31,21 +35,45 @@ type httpMetrics struct {
 func newDiscovererMetrics(reg prometheus.Registerer) discovery.DiscovererMetrics {
 	const namespace = "prometheus_sd_http"
 	m := &httpMetrics{
-		discoverDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+		discoverDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 			Namespace: namespace,
 			Name:      "discover_duration",
 			Help:      "Duration of each http discovery",
-		}),
-		failuresCount: prometheus.NewCounter(
+		}, []string{"source"}),
+		failuresCount: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Namespace: namespace,
 				Name:      "failures_total",
 				Help:      "Number of HTTP service discovery refresh failures.",
-			}),
+			}, []string{"source", "kind"}),
+		cacheHits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "cache_hits_total",
+				Help:      "Number of refreshes served from the cache of targetgroups.",
+			}, []string{"source"}),
+		cacheMisses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "cache_misses_total",
+				Help:      "Number of refreshes not found in the cache of targetgroups.",
+			}, []string{"source"}),
+		cacheStaleness: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "cache_staleness_seconds",
+				Help:      "Age of the oldest cached targetgroups.",
+			}, []string{"source"}),
+		coalescedCount: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "coalesced_requests_total",
+				Help:      "Number of refreshes sharing an in-flight upstream request.",
+			}, []string{"source"}),
 	}
 
 	m.metricRegisterer = discovery.NewMetricRegisterer(reg, []prometheus.Collector{
-		m.failuresCount, m.discoverDuration,
+		m.failuresCount, m.discoverDuration, m.cacheHits, m.cacheMisses, m.cacheStaleness, m.coalescedCount,
 	})
 
 	return m
@@ -0,0 +1,334 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Pagination strategies.
+const (
+	// PaginationPage requests pages by number and size.
+	PaginationPage = "page"
+	// PaginationOffset requests pages by offset and limit.
+	PaginationOffset = "offset"
+	// PaginationLink follows the `next` relation of the Link header, which must
+	// stay on the scheme and host of the source.
+	PaginationLink = "link"
+	// PaginationCursor passes the cursor extracted from the previous page.
+	PaginationCursor = "cursor"
+)
+
+const defaultMaxPages = 100
+
+// Pagination configures how to request all pages of upstream, which are
+// merged into one JSON body before transformation.
+type Pagination struct {
+	Type string `yaml:"type"`
+	// MaxPages fails the refresh if there are more pages, 100 by default.
+	MaxPages int `yaml:"max_pages,omitempty"`
+	// ItemsField is the dotted path of the array of items in every page,
+	// eg. `data.hosts`, pages are arrays of items themselves if it's empty.
+	// Items of all pages are merged into the first page.
+	ItemsField string `yaml:"items_field,omitempty"`
+
+	// PageParam, SizeParam and FirstPage are used by the page strategy,
+	// Size by the page and offset strategies. The last page is the one
+	// having less than Size items.
+	PageParam string `yaml:"page_param,omitempty"`
+	SizeParam string `yaml:"size_param,omitempty"`
+	FirstPage *int   `yaml:"first_page,omitempty"`
+	Size      int    `yaml:"size,omitempty"`
+
+	OffsetParam string `yaml:"offset_param,omitempty"`
+	LimitParam  string `yaml:"limit_param,omitempty"`
+
+	// CursorPath is the JSONPath of the cursor of the next page, which is
+	// passed in CursorParam. The last page is the one without cursor.
+	CursorParam string `yaml:"cursor_param,omitempty"`
+	CursorPath  string `yaml:"cursor_path,omitempty"`
+}
+
+// Validate sets the defaults and checks the parameters of the strategy.
+func (p *Pagination) Validate() error {
+	if p.MaxPages == 0 {
+		p.MaxPages = defaultMaxPages
+	}
+	if p.MaxPages < 0 {
+		return errors.New("max_pages must be positive")
+	}
+	switch p.Type {
+	case PaginationPage:
+		if p.PageParam == "" {
+			p.PageParam = "page"
+		}
+		if p.SizeParam == "" {
+			p.SizeParam = "size"
+		}
+		if p.FirstPage == nil {
+			first := 1
+			p.FirstPage = &first
+		}
+	case PaginationOffset:
+		if p.OffsetParam == "" {
+			p.OffsetParam = "offset"
+		}
+		if p.LimitParam == "" {
+			p.LimitParam = "limit"
+		}
+	case PaginationLink:
+		return nil
+	case PaginationCursor:
+		if p.CursorParam == "" {
+			return errors.New("cursor_param is required by cursor pagination")
+		}
+		if p.CursorPath == "" {
+			return errors.New("cursor_path is required by cursor pagination")
+		}
+		_, err := p.cursorPath()
+		return err
+	default:
+		return fmt.Errorf("unknown pagination type %q, must be one of %s, %s, %s or %s", p.Type, PaginationPage, PaginationOffset, PaginationLink, PaginationCursor)
+	}
+	if p.Size <= 0 {
+		return fmt.Errorf("size is required by %s pagination", p.Type)
+	}
+	return nil
+}
+
+func (p *Pagination) cursorPath() (*jsonpath.JSONPath, error) {
+	jpath := jsonpath.New("cursor").AllowMissingKeys(true)
+	if err := jpath.Parse(p.CursorPath); err != nil {
+		return nil, fmt.Errorf("invalid cursor_path: %w", err)
+	}
+	return jpath, nil
+}
+
+// paginator tracks the pages of one refresh.
+type paginator struct {
+	*Pagination
+	cursor *jsonpath.JSONPath
+
+	first any
+	items []any
+	pages int
+}
+
+func newPaginator(p *Pagination) (*paginator, error) {
+	pg := &paginator{Pagination: p}
+	if p.Type == PaginationCursor {
+		var err error
+		if pg.cursor, err = p.cursorPath(); err != nil {
+			return nil, err
+		}
+	}
+	return pg, nil
+}
+
+// firstURL returns the url of the first page.
+func (pg *paginator) firstURL(target string) (string, error) {
+	switch pg.Type {
+	case PaginationPage:
+		return withParams(target, pg.PageParam, strconv.Itoa(*pg.FirstPage), pg.SizeParam, strconv.Itoa(pg.Size))
+	case PaginationOffset:
+		return withParams(target, pg.OffsetParam, "0", pg.LimitParam, strconv.Itoa(pg.Size))
+	default:
+		return target, nil
+	}
+}
+
+// add merges a page and returns the url of the next page, which is empty if
+// it's the last one.
+func (pg *paginator) add(current string, b []byte, link string) (string, error) {
+	pg.pages++
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return "", fmt.Errorf("page %d: %w", pg.pages, err)
+	}
+	items, err := pg.itemsOf(doc)
+	if err != nil {
+		return "", fmt.Errorf("page %d: %w", pg.pages, err)
+	}
+	if pg.first == nil {
+		pg.first = doc
+	}
+	pg.items = append(pg.items, items...)
+
+	var next string
+	switch pg.Type {
+	case PaginationPage:
+		if len(items) < pg.Size {
+			return "", nil
+		}
+		next, err = withParams(current, pg.PageParam, strconv.Itoa(*pg.FirstPage+pg.pages), pg.SizeParam, strconv.Itoa(pg.Size))
+	case PaginationOffset:
+		if len(items) < pg.Size {
+			return "", nil
+		}
+		next, err = withParams(current, pg.OffsetParam, strconv.Itoa(len(pg.items)), pg.LimitParam, strconv.Itoa(pg.Size))
+	case PaginationLink:
+		if next = nextLink(link); next == "" {
+			return "", nil
+		}
+		next, err = resolve(current, next)
+	case PaginationCursor:
+		var buf bytes.Buffer
+		if err = pg.cursor.Execute(&buf, doc); err != nil {
+			return "", fmt.Errorf("page %d: failed to extract cursor: %w", pg.pages, err)
+		}
+		cursor := strings.TrimSpace(buf.String())
+		if cursor == "" || cursor == "<nil>" || cursor == "null" {
+			return "", nil
+		}
+		next, err = withParams(current, pg.CursorParam, cursor)
+	}
+	if err != nil {
+		return "", err
+	}
+	if pg.pages >= pg.MaxPages {
+		return "", fmt.Errorf("more than %d pages", pg.MaxPages)
+	}
+	return next, nil
+}
+
+// merged returns the first page with the items of all pages.
+func (pg *paginator) merged() ([]byte, error) {
+	items := pg.items
+	if items == nil {
+		items = []any{}
+	}
+	if pg.ItemsField == "" {
+		return json.Marshal(items)
+	}
+	fields := strings.Split(pg.ItemsField, ".")
+	m, ok := pg.first.(map[string]any)
+	if !ok {
+		return nil, errors.New("first page is not an object")
+	}
+	for _, f := range fields[:len(fields)-1] {
+		child, ok := m[f].(map[string]any)
+		if !ok {
+			child = map[string]any{}
+			m[f] = child
+		}
+		m = child
+	}
+	m[fields[len(fields)-1]] = items
+	return json.Marshal(pg.first)
+}
+
+// itemsOf returns the array at ItemsField of doc, a missing field means no
+// items.
+func (pg *paginator) itemsOf(doc any) ([]any, error) {
+	v := doc
+	if pg.ItemsField != "" {
+		for _, f := range strings.Split(pg.ItemsField, ".") {
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("parent of %q is not an object", f)
+			}
+			if v = m[f]; v == nil {
+				return nil, nil
+			}
+		}
+	}
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("items of %q are not an array", pg.ItemsField)
+	}
+	return items, nil
+}
+
+func withParams(target string, kv ...string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for i := 0; i+1 < len(kv); i += 2 {
+		q.Set(kv[i], kv[i+1])
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// resolve returns ref resolved against base, next links leaving the scheme
+// and host of base are rejected since the credentials of the source would
+// be sent along.
+func resolve(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid next link %q: %w", ref, err)
+	}
+	u := b.ResolveReference(r)
+	if u.Scheme != b.Scheme || u.Host != b.Host {
+		return "", fmt.Errorf("next link %q leaves %s://%s", ref, b.Scheme, b.Host)
+	}
+	return u.String(), nil
+}
+
+// nextLink returns the target of the `next` relation of a Link header,
+// eg. `<https://example.com/hosts?page=2>; rel="next"`. Commas and
+// semicolons are allowed in targets and quoted parameters.
+func nextLink(header string) string {
+	for _, link := range splitOutside(header, ',') {
+		link = strings.TrimSpace(link)
+		if !strings.HasPrefix(link, "<") {
+			continue
+		}
+		target, params, ok := strings.Cut(link[1:], ">")
+		if !ok {
+			continue
+		}
+		for _, param := range splitOutside(params, ';') {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if !strings.EqualFold(strings.TrimSpace(k), "rel") {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(v), `"`)) {
+				if strings.EqualFold(rel, "next") {
+					return target
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// splitOutside splits s around sep, except within `<...>` and quoted
+// strings.
+func splitOutside(s string, sep rune) []string {
+	var (
+		parts          []string
+		start          int
+		inTarget, quot bool
+	)
+	for i, c := range s {
+		switch {
+		case quot:
+			quot = c != '"'
+		case inTarget:
+			inTarget = c != '>'
+		case c == '"':
+			quot = true
+		case c == '<':
+			inTarget = true
+		case c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,245 @@
+package http
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func intPtr(i int) *int { return &i }
+
+func TestPaginationValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    Pagination
+		want Pagination
+		err  string
+	}{
+		{
+			name: "page defaults",
+			p:    Pagination{Type: PaginationPage, Size: 10},
+			want: Pagination{Type: PaginationPage, Size: 10, MaxPages: defaultMaxPages, PageParam: "page", SizeParam: "size", FirstPage: intPtr(1)},
+		},
+		{
+			name: "first page zero",
+			p:    Pagination{Type: PaginationPage, Size: 10, FirstPage: intPtr(0)},
+			want: Pagination{Type: PaginationPage, Size: 10, MaxPages: defaultMaxPages, PageParam: "page", SizeParam: "size", FirstPage: intPtr(0)},
+		},
+		{
+			name: "offset defaults",
+			p:    Pagination{Type: PaginationOffset, Size: 10},
+			want: Pagination{Type: PaginationOffset, Size: 10, MaxPages: defaultMaxPages, OffsetParam: "offset", LimitParam: "limit"},
+		},
+		{name: "link", p: Pagination{Type: PaginationLink}, want: Pagination{Type: PaginationLink, MaxPages: defaultMaxPages}},
+		{
+			name: "cursor",
+			p:    Pagination{Type: PaginationCursor, CursorParam: "cursor", CursorPath: "{.next}"},
+			want: Pagination{Type: PaginationCursor, MaxPages: defaultMaxPages, CursorParam: "cursor", CursorPath: "{.next}"},
+		},
+		{name: "size missing", p: Pagination{Type: PaginationPage}, err: "size is required"},
+		{name: "negative max pages", p: Pagination{Type: PaginationLink, MaxPages: -1}, err: "max_pages"},
+		{name: "cursor param missing", p: Pagination{Type: PaginationCursor, CursorPath: "{.next}"}, err: "cursor_param"},
+		{name: "cursor path invalid", p: Pagination{Type: PaginationCursor, CursorParam: "c", CursorPath: "{.next"}, err: "invalid cursor_path"},
+		{name: "unknown type", p: Pagination{Type: "scroll"}, err: "unknown pagination type"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.p.Validate()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.p, tc.want) {
+				t.Errorf("got %+v, want %+v", tc.p, tc.want)
+			}
+		})
+	}
+}
+
+type page struct {
+	body string
+	link string
+}
+
+func TestPaginator(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		p      Pagination
+		target string
+		pages  []page
+		// urls are the urls of every page, the first one included
+		urls   []string
+		merged string
+		err    string
+	}{
+		{
+			name:   "page",
+			p:      Pagination{Type: PaginationPage, Size: 2},
+			target: "http://cmdb/hosts?env=prod",
+			pages:  []page{{body: `[1,2]`}, {body: `[3,4]`}, {body: `[5]`}},
+			urls:   []string{"http://cmdb/hosts?env=prod&page=1&size=2", "http://cmdb/hosts?env=prod&page=2&size=2", "http://cmdb/hosts?env=prod&page=3&size=2"},
+			merged: `[1,2,3,4,5]`,
+		},
+		{
+			name:   "page ending with an empty page",
+			p:      Pagination{Type: PaginationPage, Size: 2, FirstPage: intPtr(0), ItemsField: "data.hosts"},
+			target: "http://cmdb/hosts",
+			pages:  []page{{body: `{"code":0,"data":{"total":2,"hosts":[1,2]}}`}, {body: `{"code":0,"data":{"total":2}}`}},
+			urls:   []string{"http://cmdb/hosts?page=0&size=2", "http://cmdb/hosts?page=1&size=2"},
+			merged: `{"code":0,"data":{"hosts":[1,2],"total":2}}`,
+		},
+		{
+			name:   "offset",
+			p:      Pagination{Type: PaginationOffset, Size: 2, ItemsField: "items"},
+			target: "http://cmdb/hosts",
+			pages:  []page{{body: `{"items":[1,2]}`}, {body: `{"items":[3]}`}},
+			urls:   []string{"http://cmdb/hosts?limit=2&offset=0", "http://cmdb/hosts?limit=2&offset=2"},
+			merged: `{"items":[1,2,3]}`,
+		},
+		{
+			name:   "link",
+			p:      Pagination{Type: PaginationLink},
+			target: "http://cmdb/hosts",
+			pages:  []page{{body: `[1]`, link: `</hosts?page=2>; rel="next", </hosts?page=9>; rel="last"`}, {body: `[2]`, link: `</hosts?page=1>; rel="first"`}},
+			urls:   []string{"http://cmdb/hosts", "http://cmdb/hosts?page=2"},
+			merged: `[1,2]`,
+		},
+		{
+			name:   "cursor",
+			p:      Pagination{Type: PaginationCursor, CursorParam: "cursor", CursorPath: "{.next}", ItemsField: "items"},
+			target: "http://cmdb/hosts",
+			pages:  []page{{body: `{"items":[1],"next":"abc"}`}, {body: `{"items":[2],"next":null}`}},
+			urls:   []string{"http://cmdb/hosts", "http://cmdb/hosts?cursor=abc"},
+			merged: `{"items":[1,2],"next":"abc"}`,
+		},
+		{
+			name:   "too many pages",
+			p:      Pagination{Type: PaginationLink, MaxPages: 2},
+			target: "http://cmdb/hosts",
+			pages:  []page{{body: `[1]`, link: `<http://cmdb/hosts?p=2>; rel=next`}, {body: `[2]`, link: `<http://cmdb/hosts?p=3>; rel=next`}},
+			urls:   []string{"http://cmdb/hosts", "http://cmdb/hosts?p=2"},
+			err:    "more than 2 pages",
+		},
+		{
+			name:   "link with commas",
+			p:      Pagination{Type: PaginationLink},
+			target: "http://cmdb/hosts?fields=ip,port",
+			pages:  []page{{body: `[1]`, link: `</hosts?fields=ip,port&page=2>; rel="next"`}, {body: `[2]`}},
+			urls:   []string{"http://cmdb/hosts?fields=ip,port", "http://cmdb/hosts?fields=ip,port&page=2"},
+			merged: `[1,2]`,
+		},
+		{
+			name:   "link to another host",
+			p:      Pagination{Type: PaginationLink},
+			target: "http://cmdb/hosts",
+			pages:  []page{{body: `[1]`, link: `<http://elsewhere/hosts?page=2>; rel="next"`}},
+			urls:   []string{"http://cmdb/hosts"},
+			err:    "leaves http://cmdb",
+		},
+		{
+			name:   "link to another scheme",
+			p:      Pagination{Type: PaginationLink},
+			target: "https://cmdb/hosts",
+			pages:  []page{{body: `[1]`, link: `<http://cmdb/hosts?page=2>; rel="next"`}},
+			urls:   []string{"https://cmdb/hosts"},
+			err:    "leaves https://cmdb",
+		},
+		{
+			name:   "items not an array",
+			p:      Pagination{Type: PaginationPage, Size: 2, ItemsField: "items"},
+			target: "http://cmdb/hosts",
+			pages:  []page{{body: `{"items":{"a":1}}`}},
+			urls:   []string{"http://cmdb/hosts?page=1&size=2"},
+			err:    "not an array",
+		},
+		{
+			name:   "invalid json",
+			p:      Pagination{Type: PaginationLink},
+			target: "http://cmdb/hosts",
+			pages:  []page{{body: `[1,`}},
+			urls:   []string{"http://cmdb/hosts"},
+			err:    "page 1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.p.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			pg, err := newPaginator(&tc.p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err := pg.firstURL(tc.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var urls []string
+			for i := 0; next != ""; i++ {
+				if i >= len(tc.pages) {
+					t.Fatalf("requested page %s beyond the last one", next)
+				}
+				urls = append(urls, next)
+				next, err = pg.add(next, []byte(tc.pages[i].body), tc.pages[i].link)
+				if err != nil {
+					break
+				}
+			}
+			if !reflect.DeepEqual(urls, tc.urls) {
+				t.Errorf("urls = %v, want %v", urls, tc.urls)
+			}
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := pg.merged()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSONEqual(t, b, tc.merged)
+		})
+	}
+}
+
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid json %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                                       "",
+		`<https://api/hosts?page=2>; rel="next"`: "https://api/hosts?page=2",
+		`<https://api/hosts?page=1>; rel="prev", <https://api/hosts?page=3>; rel="next"`:           "https://api/hosts?page=3",
+		`<https://api/hosts?page=3>; rel="last next"`:                                              "https://api/hosts?page=3",
+		`<https://api/hosts?page=3>; REL=Next`:                                                     "https://api/hosts?page=3",
+		`<https://api/hosts?page=3>; rel="last"`:                                                   "",
+		`https://api/hosts?page=3; rel="next"`:                                                     "",
+		`<https://api/hosts?fields=a,b&page=2>; rel="next"`:                                        "https://api/hosts?fields=a,b&page=2",
+		`<https://api/hosts?fields=a,b&page=1>; rel="prev", <https://api/hosts?f=a;b>; rel="next"`: "https://api/hosts?f=a;b",
+		`<https://api/hosts?page=1>; title="a, b; c"; rel="next"`:                                  "https://api/hosts?page=1",
+		`<https://api/hosts?page=2`:                                                                "",
+	} {
+		if got := nextLink(header); got != want {
+			t.Errorf("nextLink(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package nacos
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+type entry struct {
+	tgs []*targetgroup.Group
+	// expires is zero for services listed by sync, otherwise it's the
+	// expiry of a service looked up by serviceName
+	expires time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// cache holds the targetgroups of services, it's replaced as a whole by every
+// sync so that services gone are dropped, the lock is only held for swapping.
+type cache struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[key]*entry
+
+	services prometheus.Gauge
+	targets  prometheus.Gauge
+}
+
+func newCache(ttl time.Duration, services, targets prometheus.Gauge) *cache {
+	return &cache{
+		ttl:      ttl,
+		entries:  map[key]*entry{},
+		services: services,
+		targets:  targets,
+	}
+}
+
+// get returns the targetgroups of a service, an expired one is deleted so
+// that gauges don't count it anymore.
+func (c *cache) get(k key, now time.Time) ([]*targetgroup.Group, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[k]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if e.expired(now) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// it may have been replaced in between
+		if e, ok := c.entries[k]; ok && e.expired(now) {
+			delete(c.entries, k)
+			c.observe()
+		}
+		return nil, false
+	}
+	return e.tgs, true
+}
+
+// all calls fn with every service in cache.
+func (c *cache) all(now time.Time, fn func(key, []*targetgroup.Group)) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for k, e := range c.entries {
+		if !e.expired(now) {
+			fn(k, e.tgs)
+		}
+	}
+}
+
+// replace swaps in the services listed by sync, looked up services which
+// haven't expired yet are kept.
+func (c *cache) replace(synced map[key][]*targetgroup.Group, now time.Time) {
+	entries := make(map[key]*entry, len(synced))
+	for k, tgs := range synced {
+		entries[k] = &entry{tgs: tgs}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if _, ok := entries[k]; !ok && !e.expires.IsZero() && !e.expired(now) {
+			entries[k] = e
+		}
+	}
+	c.entries = entries
+	c.observe()
+}
+
+// lookup stores a service looked up by serviceName, it expires after ttl
+// unless it's listed by sync. Other expired services are deleted.
+func (c *cache) lookup(k key, tgs []*targetgroup.Group, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for other, e := range c.entries {
+		if e.expired(now) {
+			delete(c.entries, other)
+		}
+	}
+	if e, ok := c.entries[k]; ok && e.expires.IsZero() {
+		e.tgs = tgs
+	} else {
+		c.entries[k] = &entry{tgs: tgs, expires: now.Add(c.ttl)}
+	}
+	c.observe()
+}
+
+// update stores targetgroups pushed by subscription, services not in cache
+// are ignored since they may have been dropped by sync already.
+func (c *cache) update(k key, tgs []*targetgroup.Group) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[k]; ok {
+		e.tgs = tgs
+		c.observe()
+	}
+}
+
+// observe updates gauges, it's called with c.mu held.
+func (c *cache) observe() {
+	var targets int
+	for _, e := range c.entries {
+		for _, tg := range e.tgs {
+			targets += len(tg.Targets)
+		}
+	}
+	c.services.Set(float64(len(c.entries)))
+	c.targets.Set(float64(targets))
+}
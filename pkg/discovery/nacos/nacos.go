@@ -2,8 +2,10 @@ package nacos
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"sync"
 	"time"
 
@@ -15,10 +17,14 @@ import (
 	"github.com/nacos-group/nacos-sdk-go/v2/clients/naming_client"
 	"github.com/nacos-group/nacos-sdk-go/v2/common/constant"
 	nacoslogger "github.com/nacos-group/nacos-sdk-go/v2/common/logger"
+	nacosmodel "github.com/nacos-group/nacos-sdk-go/v2/model"
 	"github.com/nacos-group/nacos-sdk-go/v2/vo"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/fengxsong/httpsd/pkg/discovery"
 	"github.com/fengxsong/httpsd/pkg/transformer/nacos"
@@ -32,11 +38,25 @@ type options struct {
 	port        uint64
 	username    string
 	password    string
-	namespace   string
+	namespaces  []string
+	groups      []string
 	exclude     []string
 	include     []string
 	interval    time.Duration
 	quiet       bool
+	subscribe   bool
+	lookupTTL   time.Duration
+	concurrency int
+
+	// metrics are shared by all discoverers built on reload
+	duration  *prometheus.HistogramVec
+	coalesced *prometheus.CounterVec
+	pushes    *prometheus.CounterVec
+	services  prometheus.Gauge
+	targets   prometheus.Gauge
+
+	syncFailures prometheus.Counter
+	lastSuccess  prometheus.Gauge
 }
 
 func (o *options) AddFlags(app *kingpin.Application) {
@@ -45,10 +65,14 @@ func (o *options) AddFlags(app *kingpin.Application) {
 	app.Flag("nacos.username", "username for basicauth").Default("").StringVar(&o.username)
 	app.Flag("nacos.password", "password for basicauth").Default("").StringVar(&o.password)
 	app.Flag("nacos.quiet", "discard noisy logging of nacos sdk").Default("true").BoolVar(&o.quiet)
-	app.Flag("nacos.namespace", "namespace id of services").Default("").StringVar(&o.namespace)
+	app.Flag("nacos.namespace", "namespace ids of services, can be repeated").Default("").StringsVar(&o.namespaces)
+	app.Flag("nacos.group", "groups of services, can be repeated").Default(constant.DEFAULT_GROUP).StringsVar(&o.groups)
 	app.Flag("nacos.exclude", "pattern or regexp of serviceName to be excluded").Default("").StringsVar(&o.exclude)
 	app.Flag("nacos.include", "pattern or regexp of serviceName to be included").Default("").StringsVar(&o.include)
-	app.Flag("nacos.interval", "sync interval").Default("60s").DurationVar(&o.interval)
+	app.Flag("nacos.interval", "interval of full sync, which reconciles the cache updated by subscriptions").Default("60s").DurationVar(&o.interval)
+	app.Flag("nacos.subscribe", "subscribe to services to get instance changes pushed").Default("true").BoolVar(&o.subscribe)
+	app.Flag("nacos.concurrency", "max number of services fetched concurrently by sync").Default("16").IntVar(&o.concurrency)
+	app.Flag("nacos.lookup-ttl", "ttl of services looked up by serviceName but not listed by sync").Default("5m").DurationVar(&o.lookupTTL)
 }
 
 func (o *options) Build(logger log.Logger, registerer prometheus.Registerer) (discovery.Discoverer, error) {
@@ -57,50 +81,112 @@ func (o *options) Build(logger log.Logger, registerer prometheus.Registerer) (di
 		l = log.NewNopLogger()
 	}
 	nacoslogger.SetLogger(&wrapLogger{l})
-
-	sc := []constant.ServerConfig{}
-	for _, addr := range o.ipAddresses {
-		sc = append(sc, *constant.NewServerConfig(addr, o.port))
-	}
-	if o.namespace == "" {
-		level.Warn(logger).Log("msg", "--nacos.namespace is missing, fallback to 'public' namespace")
-	}
-	cc := constant.NewClientConfig(constant.WithUsername(o.username), constant.WithPassword(o.password), constant.WithNamespaceId(o.namespace))
-	client, err := clients.NewNamingClient(
-		vo.NacosClientParam{
-			ClientConfig:  cc,
-			ServerConfigs: sc,
-		},
-	)
-	if err != nil {
-		return nil, err
+	if o.concurrency < 1 {
+		return nil, fmt.Errorf("--nacos.concurrency must be positive")
 	}
+
 	var exclude, include []*regexp.Regexp
 	for _, pattern := range o.exclude {
 		if pattern != "" {
-			exclude = append(exclude, regexp.MustCompile(pattern))
+			reg, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			exclude = append(exclude, reg)
 		}
 	}
 	for _, pattern := range o.include {
 		if pattern != "" {
-			include = append(include, regexp.MustCompile(pattern))
+			reg, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			include = append(include, reg)
 		}
 	}
-	discoverer := &impl{
-		o:       o,
-		exclude: exclude,
-		include: include,
-		client:  client,
-		cache:   sync.Map{},
-		logger:  log.With(logger, "discoverer", name),
-		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	sc := []constant.ServerConfig{}
+	for _, addr := range o.ipAddresses {
+		sc = append(sc, *constant.NewServerConfig(addr, o.port))
+	}
+	if slices.Contains(o.namespaces, "") {
+		level.Warn(logger).Log("msg", "--nacos.namespace is missing, fallback to 'public' namespace")
+	}
+	// naming client is bound to one namespace
+	namingClients := make(map[string]naming_client.INamingClient, len(o.namespaces))
+	for _, namespace := range o.namespaces {
+		if _, ok := namingClients[namespace]; ok {
+			continue
+		}
+		cc := constant.NewClientConfig(constant.WithUsername(o.username), constant.WithPassword(o.password), constant.WithNamespaceId(namespace))
+		client, err := clients.NewNamingClient(
+			vo.NacosClientParam{
+				ClientConfig:  cc,
+				ServerConfigs: sc,
+			},
+		)
+		if err != nil {
+			for _, c := range namingClients {
+				c.CloseClient()
+			}
+			return nil, err
+		}
+		namingClients[namespace] = client
+	}
+	if o.duration == nil {
+		o.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 			Subsystem: "nacos",
 			Name:      "scrape_duration",
 			Help:      "duration of service discovery process",
-		}, []string{"service"}),
+		}, []string{"service"})
+		o.coalesced = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "nacos",
+			Name:      "coalesced_requests_total",
+			Help:      "number of lookups sharing an in-flight request of the same service",
+		}, []string{"service"})
+		o.pushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "nacos",
+			Name:      "push_events_total",
+			Help:      "number of instance changes pushed by nacos server",
+		}, []string{"service"})
+		o.services = prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "nacos",
+			Name:      "cached_services",
+			Help:      "number of services in cache",
+		})
+		o.targets = prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "nacos",
+			Name:      "cached_targets",
+			Help:      "number of targets in cache",
+		})
+		o.syncFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: "nacos",
+			Name:      "sync_failures_total",
+			Help:      "number of failed syncs, including partial ones",
+		})
+		o.lastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "nacos",
+			Name:      "last_successful_sync_timestamp_seconds",
+			Help:      "timestamp of the last sync without any error",
+		})
+		registerer.MustRegister(o.duration, o.coalesced, o.pushes, o.services, o.targets, o.syncFailures, o.lastSuccess)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	discoverer := &impl{
+		o:             o,
+		exclude:       exclude,
+		include:       include,
+		clients:       namingClients,
+		cache:         newCache(o.lookupTTL, o.services, o.targets),
+		logger:        log.With(logger, "discoverer", name),
+		duration:      o.duration,
+		coalesced:     o.coalesced,
+		pushes:        o.pushes,
+		syncFailures:  o.syncFailures,
+		lastSuccess:   o.lastSuccess,
+		subscriptions: map[key]*vo.SubscribeParam{},
+		cancel:        cancel,
 	}
-	registerer.MustRegister(discoverer.duration)
-	go discoverer.sync(context.Background())
+	go discoverer.sync(ctx)
 	return discoverer, nil
 }
 
@@ -109,18 +195,51 @@ type impl struct {
 	exclude []*regexp.Regexp
 	include []*regexp.Regexp
 
-	cache    sync.Map
-	mu       sync.Mutex
-	client   naming_client.INamingClient
-	logger   log.Logger
-	duration *prometheus.HistogramVec
+	cache   *cache
+	clients map[string]naming_client.INamingClient
+	group   singleflight.Group
+	logger  log.Logger
+
+	// the same param is required to unsubscribe since the callback is
+	// compared by its address
+	subscriptions map[key]*vo.SubscribeParam
+
+	duration  *prometheus.HistogramVec
+	coalesced *prometheus.CounterVec
+	pushes    *prometheus.CounterVec
+
+	syncFailures prometheus.Counter
+	lastSuccess  prometheus.Gauge
+	statusMu     sync.Mutex
+	status       discovery.Status
+
+	cancel context.CancelFunc
+}
+
+// Stop implements discovery.Stopper.
+func (impl *impl) Stop() {
+	impl.cancel()
+	for _, client := range impl.clients {
+		client.CloseClient()
+	}
+}
+
+// key identifies a service across namespaces and groups.
+type key struct {
+	namespace string
+	group     string
+	service   string
+}
+
+func (k key) String() string {
+	return k.namespace + "/" + k.group + "/" + k.service
 }
 
-func (impl *impl) listAllServices(_ context.Context) ([]string, error) {
+func (impl *impl) listAllServices(_ context.Context, namespace, group string) ([]string, error) {
 	var services []string
 	pageno := 1
 	for {
-		sl, err := impl.client.GetAllServicesInfo(vo.GetAllServiceInfoParam{NameSpace: impl.o.namespace, PageNo: uint32(pageno), PageSize: 256})
+		sl, err := impl.clients[namespace].GetAllServicesInfo(vo.GetAllServiceInfoParam{NameSpace: namespace, GroupName: group, PageNo: uint32(pageno), PageSize: 256})
 		if err != nil {
 			return nil, err
 		}
@@ -148,106 +267,307 @@ func (impl *impl) filter(s string) bool {
 	return false
 }
 
-func (impl *impl) listServices(ctx context.Context) ([]string, error) {
+// listServices lists services of all configured namespaces and groups.
+func (impl *impl) listServices(ctx context.Context) ([]key, error) {
 	now := time.Now()
-	all, err := impl.listAllServices(ctx)
-	if err != nil {
-		return nil, err
-	}
-	var ret []string
-	for _, s := range all {
-		if impl.filter(s) {
-			continue
+	var ret []key
+	for namespace := range impl.clients {
+		for _, group := range impl.o.groups {
+			all, err := impl.listAllServices(ctx, namespace, group)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range all {
+				if impl.filter(s) {
+					continue
+				}
+				ret = append(ret, key{namespace: namespace, group: group, service: s})
+			}
 		}
-		ret = append(ret, s)
 	}
 	impl.duration.WithLabelValues("all").Observe(float64(time.Since(now).Seconds()))
 	return ret, nil
 }
 
-func (impl *impl) getTargetgroupForService(s string) ([]*targetgroup.Group, error) {
-	service, err := impl.client.GetService(vo.GetServiceParam{ServiceName: s})
+func (impl *impl) getTargetgroupForService(k key) ([]*targetgroup.Group, error) {
+	client, ok := impl.clients[k.namespace]
+	if !ok {
+		return nil, discovery.NewError(discovery.KindInvalidQuery, fmt.Errorf("namespace %q is not configured", k.namespace))
+	}
+	service, err := client.GetService(vo.GetServiceParam{ServiceName: k.service, GroupName: k.group})
 	if err != nil {
-		return nil, err
+		return nil, discovery.UpstreamError(0, err)
 	}
-	return nacos.Transform(service)
+	if service.GroupName == "" {
+		service.GroupName = k.group
+	}
+	return nacos.Transform(service, k.namespace)
 }
 
-func (impl *impl) sync(ctx context.Context) error {
-	ticker := time.NewTicker(impl.o.interval)
-	defer ticker.Stop()
+// subscribe subscribes to the newly listed services and unsubscribes from
+// the ones gone, it's only called by the sync goroutine.
+func (impl *impl) subscribe(services []key) {
+	listed := make(map[key]struct{}, len(services))
+	for _, k := range services {
+		listed[k] = struct{}{}
+		if _, ok := impl.subscriptions[k]; ok {
+			continue
+		}
+		param := &vo.SubscribeParam{
+			ServiceName: k.service,
+			GroupName:   k.group,
+			SubscribeCallback: func(instances []nacosmodel.Instance, err error) {
+				impl.onPush(k, instances, err)
+			},
+		}
+		if err := impl.clients[k.namespace].Subscribe(param); err != nil {
+			level.Warn(impl.logger).Log("msg", "failed to subscribe", "service", k, "err", err)
+			continue
+		}
+		impl.subscriptions[k] = param
+	}
+	for k, param := range impl.subscriptions {
+		if _, ok := listed[k]; ok {
+			continue
+		}
+		if err := impl.clients[k.namespace].Unsubscribe(param); err != nil {
+			level.Warn(impl.logger).Log("msg", "failed to unsubscribe", "service", k, "err", err)
+		}
+		delete(impl.subscriptions, k)
+	}
+}
+
+func (impl *impl) onPush(k key, instances []nacosmodel.Instance, err error) {
+	if err != nil {
+		level.Warn(impl.logger).Log("msg", "error pushed by subscription", "service", k, "err", err)
+		return
+	}
+	impl.pushes.WithLabelValues(k.service).Inc()
+	tgs, err := nacos.Transform(nacosmodel.Service{Name: k.service, GroupName: k.group, Hosts: instances}, k.namespace)
+	if err != nil {
+		level.Warn(impl.logger).Log("msg", "failed to transform pushed instances", "service", k, "err", err)
+		return
+	}
+	impl.cache.update(k, tgs)
+}
 
-	q := make(chan struct{}, 1)
-	q <- struct{}{}
+// sync lists and fetches all services every interval until ctx is done,
+// failures are retried with exponential backoff.
+func (impl *impl) sync(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
+	var backoff time.Duration
 	for {
 		select {
-		case <-ticker.C:
-			q <- struct{}{}
-		case <-q:
-			if err := func() error {
-				level.Debug(impl.logger).Log("msg", "refreshing targetgroups in cache")
-				impl.mu.Lock()
-				defer impl.mu.Unlock()
-				services, err := impl.listServices(ctx)
-				if err != nil {
-					return err
-				}
-				eg, _ := errgroup.WithContext(ctx)
-				for i := range services {
-					s := services[i]
-					eg.Go(func() error {
-						now := time.Now()
-						tgs, err := impl.getTargetgroupForService(s)
-						if err != nil {
-							return err
-						}
-						impl.duration.WithLabelValues(s).Observe(float64(time.Since(now).Seconds()))
-						impl.cache.Store(s, tgs)
-						return nil
-					})
-				}
-				return eg.Wait()
-			}(); err != nil {
-				return err
-			}
+		case <-timer.C:
 		case <-ctx.Done():
-			return ctx.Err()
+			return
+		}
+		next := impl.o.interval
+		if err := impl.syncOnce(ctx); err != nil {
+			impl.syncFailures.Inc()
+			backoff = nextBackoff(backoff, impl.o.interval)
+			next = backoff
+			level.Error(impl.logger).Log("msg", "failed to sync services", "retry_in", backoff, "err", err)
+			impl.setStatus(err)
+		} else {
+			backoff = 0
+			impl.lastSuccess.SetToCurrentTime()
+			impl.setStatus(nil)
 		}
+		timer.Reset(next)
+	}
+}
+
+const minBackoff = time.Second
+
+func nextBackoff(current, limit time.Duration) time.Duration {
+	if current < minBackoff {
+		return min(minBackoff, limit)
 	}
+	return min(current*2, limit)
 }
 
+// syncOnce replaces the cache with the listed services, the previous
+// targetgroups are kept for services failed to fetch. The sync is completed
+// unless listing or fetching every service fails, which makes the
+// discoverer ready.
+func (impl *impl) syncOnce(ctx context.Context) error {
+	level.Debug(impl.logger).Log("msg", "refreshing targetgroups in cache")
+	services, err := impl.listServices(ctx)
+	if err != nil {
+		return err
+	}
+	if impl.o.subscribe {
+		impl.subscribe(services)
+	}
+
+	var (
+		mu     sync.Mutex
+		errs   []error
+		synced = make(map[key][]*targetgroup.Group, len(services))
+		eg     errgroup.Group
+		now    = time.Now()
+	)
+	eg.SetLimit(impl.o.concurrency)
+	for i := range services {
+		k := services[i]
+		eg.Go(func() error {
+			start := time.Now()
+			tgs, err := impl.getTargetgroupForService(k)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("service %s: %w", k, err))
+				if tgs, ok := impl.cache.get(k, now); ok {
+					synced[k] = tgs
+				}
+				return nil
+			}
+			impl.duration.WithLabelValues(k.service).Observe(float64(time.Since(start).Seconds()))
+			synced[k] = tgs
+			return nil
+		})
+	}
+	eg.Wait()
+	impl.cache.replace(synced, time.Now())
+	if len(services) == 0 || len(errs) < len(services) {
+		impl.setSynced(time.Now())
+	}
+	return errors.Join(errs...)
+}
+
+// setSynced records a completed sync.
+func (impl *impl) setSynced(now time.Time) {
+	impl.statusMu.Lock()
+	defer impl.statusMu.Unlock()
+	impl.status.Ready = true
+	impl.status.LastSync = &now
+}
+
+func (impl *impl) setStatus(err error) {
+	impl.statusMu.Lock()
+	defer impl.statusMu.Unlock()
+	if err != nil {
+		impl.status.LastError = err.Error()
+		return
+	}
+	now := time.Now()
+	impl.status.LastError = ""
+	impl.status.LastSuccess = &now
+}
+
+// Config implements discovery.Configurer.
+func (impl *impl) Config() any {
+	return struct {
+		Addresses   []string       `yaml:"addresses"`
+		Port        uint64         `yaml:"port"`
+		Username    string         `yaml:"username,omitempty"`
+		Password    config.Secret  `yaml:"password,omitempty"`
+		Namespaces  []string       `yaml:"namespaces"`
+		Groups      []string       `yaml:"groups"`
+		Exclude     []string       `yaml:"exclude,omitempty"`
+		Include     []string       `yaml:"include,omitempty"`
+		Interval    model.Duration `yaml:"interval"`
+		Subscribe   bool           `yaml:"subscribe"`
+		Concurrency int            `yaml:"concurrency"`
+		LookupTTL   model.Duration `yaml:"lookup_ttl"`
+	}{
+		Addresses:   impl.o.ipAddresses,
+		Port:        impl.o.port,
+		Username:    impl.o.username,
+		Password:    config.Secret(impl.o.password),
+		Namespaces:  impl.o.namespaces,
+		Groups:      impl.o.groups,
+		Exclude:     slices.DeleteFunc(slices.Clone(impl.o.exclude), func(s string) bool { return s == "" }),
+		Include:     slices.DeleteFunc(slices.Clone(impl.o.include), func(s string) bool { return s == "" }),
+		Interval:    model.Duration(impl.o.interval),
+		Subscribe:   impl.o.subscribe,
+		Concurrency: impl.o.concurrency,
+		LookupTTL:   model.Duration(impl.o.lookupTTL),
+	}
+}
+
+// Status implements discovery.StatusReporter.
+func (impl *impl) Status() discovery.Status {
+	impl.statusMu.Lock()
+	defer impl.statusMu.Unlock()
+	return impl.status
+}
+
+// Refresh returns the cached targetgroups, instances are filtered by
+// `healthyOnly`, `enabledOnly` and `minWeight` query parameters.
 func (impl *impl) Refresh(ctx context.Context, q url.Values) ([]*targetgroup.Group, error) {
+	filter, err := nacos.ParseFilter(q)
+	if err != nil {
+		return nil, discovery.NewError(discovery.KindInvalidQuery, err)
+	}
 	tgs, err := impl.refresh(ctx, q)
 	if err != nil {
 		return nil, err
 	}
-	return utils.Grouping(tgs), nil
+	return utils.Grouping(filter.Filter(tgs)), nil
 }
 
+// refresh returns targetgroups of the service given by `serviceName`, or of
+// all cached services, optionally filtered by `namespaceId` and `groupName`.
+// The first configured namespace and DEFAULT_GROUP are used to look up a
+// service if they're absent.
 func (impl *impl) refresh(_ context.Context, q url.Values) ([]*targetgroup.Group, error) {
 	if sn := q.Get("serviceName"); sn != "" {
-		if v, ok := impl.cache.Load(sn); ok {
-			return v.([]*targetgroup.Group), nil
+		k := key{namespace: impl.o.namespaces[0], group: constant.DEFAULT_GROUP, service: sn}
+		if q.Has("namespaceId") {
+			k.namespace = impl.namespace(q.Get("namespaceId"))
+		}
+		if group := q.Get("groupName"); group != "" {
+			k.group = group
+		}
+		if tgs, ok := impl.cache.get(k, time.Now()); ok {
+			return tgs, nil
+		}
+		coalesced := true
+		v, err, _ := impl.group.Do(k.String(), func() (any, error) {
+			coalesced = false
+			tgs, err := impl.getTargetgroupForService(k)
+			if err != nil {
+				return nil, err
+			}
+			impl.cache.lookup(k, tgs, time.Now())
+			return tgs, nil
+		})
+		if coalesced {
+			impl.coalesced.WithLabelValues(sn).Inc()
 		}
-		tgs, err := impl.getTargetgroupForService(sn)
 		if err != nil {
 			return nil, err
 		}
-		impl.cache.Store(sn, tgs)
-		return tgs, nil
+		return v.([]*targetgroup.Group), nil
 	}
-	impl.mu.Lock()
-	defer impl.mu.Unlock()
-
 	var tgs []*targetgroup.Group
-	impl.cache.Range(func(key, value any) bool {
-		tgs = append(tgs, value.([]*targetgroup.Group)...)
-		return true
+	impl.cache.all(time.Now(), func(k key, v []*targetgroup.Group) {
+		if q.Has("namespaceId") && nacos.NamespaceID(k.namespace) != nacos.NamespaceID(q.Get("namespaceId")) {
+			return
+		}
+		if group := q.Get("groupName"); group != "" && k.group != group {
+			return
+		}
+		tgs = append(tgs, v...)
 	})
 	return tgs, nil
 }
 
+// namespace returns the configured namespace matching the one of a query,
+// `public` and the empty namespace are the same.
+func (impl *impl) namespace(namespace string) string {
+	for configured := range impl.clients {
+		if nacos.NamespaceID(configured) == nacos.NamespaceID(namespace) {
+			return configured
+		}
+	}
+	return namespace
+}
+
 type wrapLogger struct {
 	log.Logger
 }
@@ -0,0 +1,194 @@
+package nacos
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/naming_client"
+	nacosmodel "github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func newTestImpl(namespaces ...string) *impl {
+	clients := map[string]naming_client.INamingClient{}
+	for _, namespace := range namespaces {
+		clients[namespace] = nil
+	}
+	return &impl{
+		o:        &options{namespaces: namespaces, groups: []string{"DEFAULT_GROUP"}, concurrency: 2},
+		clients:  clients,
+		cache:    newCache(time.Minute, prometheus.NewGauge(prometheus.GaugeOpts{Name: "services"}), prometheus.NewGauge(prometheus.GaugeOpts{Name: "targets"})),
+		logger:   log.NewNopLogger(),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration"}, []string{"service"}),
+	}
+}
+
+func group(addr string) []*targetgroup.Group {
+	return []*targetgroup.Group{{Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(addr)}}}}
+}
+
+func TestNamespace(t *testing.T) {
+	for _, tc := range []struct {
+		configured []string
+		query      string
+		want       string
+	}{
+		{configured: []string{""}, query: "public", want: ""},
+		{configured: []string{""}, query: "", want: ""},
+		{configured: []string{"public"}, query: "", want: "public"},
+		{configured: []string{"", "dev"}, query: "dev", want: "dev"},
+		{configured: []string{""}, query: "unknown", want: "unknown"},
+	} {
+		if got := newTestImpl(tc.configured...).namespace(tc.query); got != tc.want {
+			t.Errorf("namespace(%q) of %q = %q, want %q", tc.query, tc.configured, got, tc.want)
+		}
+	}
+}
+
+func TestRefreshByNamespace(t *testing.T) {
+	impl := newTestImpl("", "dev")
+	impl.cache.replace(map[key][]*targetgroup.Group{
+		{namespace: "", group: "DEFAULT_GROUP", service: "a"}:    group("a:80"),
+		{namespace: "dev", group: "DEFAULT_GROUP", service: "b"}: group("b:80"),
+	}, time.Now())
+
+	for _, tc := range []struct {
+		namespace string
+		want      model.LabelValue
+	}{
+		{namespace: "public", want: "a:80"},
+		{namespace: "", want: "a:80"},
+		{namespace: "dev", want: "b:80"},
+	} {
+		tgs, err := impl.refresh(context.Background(), url.Values{"namespaceId": {tc.namespace}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tgs) != 1 || tgs[0].Targets[0][model.AddressLabel] != tc.want {
+			t.Errorf("targetgroups of namespace %q = %v, want %s", tc.namespace, tgs, tc.want)
+		}
+	}
+
+	// looked up services are served from cache by the namespace of labels
+	tgs, err := impl.refresh(context.Background(), url.Values{"namespaceId": {"public"}, "serviceName": {"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tgs) != 1 || tgs[0].Targets[0][model.AddressLabel] != "a:80" {
+		t.Errorf("targetgroups of service a = %v", tgs)
+	}
+}
+
+// namingClient is a stand-in of nacos serving services by name, services
+// without hosts fail to fetch.
+type namingClient struct {
+	naming_client.INamingClient
+	listErr  error
+	services map[string][]nacosmodel.Instance
+}
+
+func (c *namingClient) GetAllServicesInfo(vo.GetAllServiceInfoParam) (nacosmodel.ServiceList, error) {
+	if c.listErr != nil {
+		return nacosmodel.ServiceList{}, c.listErr
+	}
+	var names []string
+	for name := range c.services {
+		names = append(names, name)
+	}
+	return nacosmodel.ServiceList{Count: int64(len(names)), Doms: names}, nil
+}
+
+func (c *namingClient) GetService(param vo.GetServiceParam) (nacosmodel.Service, error) {
+	hosts := c.services[param.ServiceName]
+	if len(hosts) == 0 {
+		return nacosmodel.Service{}, errors.New("service not found")
+	}
+	return nacosmodel.Service{Name: param.ServiceName, Hosts: hosts}, nil
+}
+
+func TestSyncOnceReadiness(t *testing.T) {
+	instance := []nacosmodel.Instance{{Ip: "10.0.0.1", Port: 80, Healthy: true, Enable: true}}
+	for _, tc := range []struct {
+		name   string
+		client *namingClient
+		ready  bool
+		err    bool
+	}{
+		{name: "all fetched", client: &namingClient{services: map[string][]nacosmodel.Instance{"a": instance, "b": instance}}, ready: true},
+		{name: "no services", client: &namingClient{services: map[string][]nacosmodel.Instance{}}, ready: true},
+		{name: "partial failure", client: &namingClient{services: map[string][]nacosmodel.Instance{"a": instance, "b": nil}}, ready: true, err: true},
+		{name: "total failure", client: &namingClient{services: map[string][]nacosmodel.Instance{"a": nil, "b": nil}}, err: true},
+		{name: "listing failure", client: &namingClient{listErr: errors.New("connection refused")}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			impl := newTestImpl("")
+			impl.clients[""] = tc.client
+			err := impl.syncOnce(context.Background())
+			if (err != nil) != tc.err {
+				t.Errorf("got error %v, want error %t", err, tc.err)
+			}
+			status := impl.Status()
+			if status.Ready != tc.ready {
+				t.Errorf("ready = %t, want %t", status.Ready, tc.ready)
+			}
+			if (status.LastSync != nil) != tc.ready {
+				t.Errorf("last sync = %v, want it set %t", status.LastSync, tc.ready)
+			}
+		})
+	}
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.Gauge.GetValue()
+}
+
+func TestCacheExpiry(t *testing.T) {
+	services, targets := prometheus.NewGauge(prometheus.GaugeOpts{Name: "services"}), prometheus.NewGauge(prometheus.GaugeOpts{Name: "targets"})
+	c := newCache(time.Minute, services, targets)
+	now := time.Now()
+	synced, a, b := key{service: "synced"}, key{service: "a"}, key{service: "b"}
+	c.replace(map[key][]*targetgroup.Group{synced: group("synced:80")}, now)
+	c.lookup(a, group("a:80"), now)
+	c.lookup(b, group("b:80"), now.Add(30*time.Second))
+	if got := gaugeValue(t, services); got != 3 {
+		t.Fatalf("services = %v, want 3", got)
+	}
+
+	// an expired lookup is deleted once it's found expired
+	if _, ok := c.get(a, now.Add(time.Minute+time.Second)); ok {
+		t.Error("expired service served")
+	}
+	if got, want := gaugeValue(t, services), 2.0; got != want {
+		t.Errorf("services = %v, want %v", got, want)
+	}
+	if got, want := gaugeValue(t, targets), 2.0; got != want {
+		t.Errorf("targets = %v, want %v", got, want)
+	}
+
+	// other expired lookups are deleted by the next lookup
+	c.lookup(a, group("a:80"), now.Add(2*time.Minute))
+	if _, ok := c.entries[b]; ok {
+		t.Error("expired service kept by lookup")
+	}
+	if got, want := gaugeValue(t, services), 2.0; got != want {
+		t.Errorf("services = %v, want %v", got, want)
+	}
+
+	// services listed by sync never expire
+	if _, ok := c.get(synced, now.Add(time.Hour)); !ok {
+		t.Error("synced service expired")
+	}
+}
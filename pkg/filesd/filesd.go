@@ -0,0 +1,191 @@
+package filesd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/fengxsong/httpsd/pkg/output"
+)
+
+// RefreshFunc returns the targetgroups for the query, which is the same as
+// the one of the targets endpoint. It returns ErrNotReady if the discoverer
+// hasn't completed its first sync, so that its empty cache doesn't replace
+// the targets of a previous run.
+type RefreshFunc func(context.Context, url.Values) ([]*targetgroup.Group, error)
+
+// ErrNotReady is returned by RefreshFunc if the discoverer isn't ready yet,
+// outputs are left untouched until it is.
+var ErrNotReady = errors.New("discoverer is not ready")
+
+type outputFile struct {
+	path string
+	q    url.Values
+}
+
+type Options struct {
+	outputs  []string
+	interval time.Duration
+}
+
+func (o *Options) AddFlags(app *kingpin.Application) {
+	app.Flag("file-sd.output", "write targetgroups into file in file_sd format periodically, in form of <path>=<query>, eg. /etc/prometheus/sd/foo.json=discovery=nacos&serviceName=foo. Format is determined by extension of path, .json, .yml or .yaml").StringsVar(&o.outputs)
+	app.Flag("file-sd.interval", "interval of writing file_sd files").Default("60s").DurationVar(&o.interval)
+}
+
+// Build returns nil if there's no output configured.
+func (o *Options) Build(refresh RefreshFunc, logger log.Logger) (*Writer, error) {
+	if len(o.outputs) == 0 {
+		return nil, nil
+	}
+	w := &Writer{
+		interval: o.interval,
+		refresh:  refresh,
+		logger:   log.With(logger, "component", "file-sd"),
+	}
+	for _, s := range o.outputs {
+		path, rawQuery, _ := strings.Cut(s, "=")
+		if path == "" {
+			return nil, fmt.Errorf("path is missing in file_sd output %q", s)
+		}
+		if _, err := marshal(path, nil); err != nil {
+			return nil, err
+		}
+		q, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query of file_sd output %q: %w", s, err)
+		}
+		w.outputs = append(w.outputs, outputFile{path: path, q: q})
+	}
+	return w, nil
+}
+
+// Writer writes the targetgroups of every output into files.
+type Writer struct {
+	outputs  []outputFile
+	interval time.Duration
+	refresh  RefreshFunc
+	logger   log.Logger
+}
+
+// notReadyRetry is the interval of retrying outputs skipped since their
+// discoverer wasn't ready, if it's shorter than the interval of writing.
+const notReadyRetry = time.Second
+
+// Run writes all outputs on every interval until ctx is done, outputs
+// skipped since their discoverer wasn't ready are retried in between.
+func (w *Writer) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var s schedule
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+		timer.Reset(time.Until(w.cycle(ctx, &s, time.Now())))
+	}
+}
+
+// schedule tracks the outputs to write across cycles.
+type schedule struct {
+	// next is when all outputs are written again
+	next    time.Time
+	skipped []outputFile
+}
+
+// cycle writes all outputs if they're due at now, otherwise the ones skipped
+// by the previous cycle only. It returns when the next cycle is.
+func (w *Writer) cycle(ctx context.Context, s *schedule, now time.Time) time.Time {
+	pending := s.skipped
+	if !now.Before(s.next) {
+		pending, s.next = w.outputs, now.Add(w.interval)
+	}
+	s.skipped = nil
+	for _, o := range pending {
+		if err := w.write(ctx, o); errors.Is(err, ErrNotReady) {
+			level.Debug(w.logger).Log("msg", "skip writing file_sd output until discoverer is ready", "path", o.path)
+			s.skipped = append(s.skipped, o)
+		} else if err != nil {
+			level.Error(w.logger).Log("msg", "failed to write file_sd output", "path", o.path, "err", err)
+		}
+	}
+	if retry := now.Add(notReadyRetry); len(s.skipped) > 0 && retry.Before(s.next) {
+		return retry
+	}
+	return s.next
+}
+
+func (w *Writer) write(ctx context.Context, o outputFile) error {
+	tgs, err := w.refresh(ctx, o.q)
+	if err != nil {
+		return err
+	}
+	b, err := marshal(o.path, tgs)
+	if err != nil {
+		return err
+	}
+	if current, err := os.ReadFile(o.path); err == nil && bytes.Equal(current, b) {
+		return nil
+	}
+	if err = writeFileAtomic(o.path, b); err != nil {
+		return err
+	}
+	level.Debug(w.logger).Log("msg", "file_sd output updated", "path", o.path, "targetgroups", len(tgs))
+	return nil
+}
+
+func marshal(path string, tgs []*targetgroup.Group) ([]byte, error) {
+	var format string
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		format = output.Default
+	case ".yml", ".yaml":
+		format = "file_sd"
+	default:
+		return nil, fmt.Errorf("unsupported file_sd extension %q of %s", ext, path)
+	}
+	var buf bytes.Buffer
+	if err := output.Get(format).Encode(&buf, tgs, true); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic writes into a temporary file in the same directory then
+// renames it, so readers never see a partial file.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
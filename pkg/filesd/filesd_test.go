@@ -0,0 +1,82 @@
+package filesd
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestWriterSkipsNotReady(t *testing.T) {
+	dir := t.TempDir()
+	nacos, http := filepath.Join(dir, "nacos.json"), filepath.Join(dir, "http.yml")
+	previous := []byte("previous run\n")
+	if err := os.WriteFile(nacos, previous, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ready     bool
+		refreshes = map[string]int{}
+	)
+	const interval = time.Hour
+	o := &Options{outputs: []string{nacos + "=discovery=nacos", http + "=discovery=http"}, interval: interval}
+	w, err := o.Build(func(_ context.Context, q url.Values) ([]*targetgroup.Group, error) {
+		name := q.Get("discovery")
+		refreshes[name]++
+		if name == "nacos" && !ready {
+			return nil, ErrNotReady
+		}
+		return []*targetgroup.Group{{Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(name + ":80")}}}}, nil
+	}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(path string) string {
+		b, _ := os.ReadFile(path)
+		return string(b)
+	}
+
+	var s schedule
+	start := time.Now()
+	// the http output is written, nacos is retried soon
+	if next := w.cycle(context.Background(), &s, start); !next.Equal(start.Add(notReadyRetry)) {
+		t.Errorf("next cycle in %s, want %s", next.Sub(start), notReadyRetry)
+	}
+	if got := read(nacos); got != string(previous) {
+		t.Fatalf("output replaced before the discoverer is ready: %q", got)
+	}
+	if got, want := read(http), "- targets:\n  - http:80\n"; got != want {
+		t.Fatalf("got output %q, want %q", got, want)
+	}
+
+	// retries don't write outputs written already
+	if next := w.cycle(context.Background(), &s, start.Add(notReadyRetry)); !next.Equal(start.Add(2 * notReadyRetry)) {
+		t.Errorf("next cycle in %s, want %s", next.Sub(start), 2*notReadyRetry)
+	}
+	if refreshes["nacos"] != 2 || refreshes["http"] != 1 {
+		t.Errorf("got refreshes %v, want nacos twice and http once", refreshes)
+	}
+
+	ready = true
+	if next := w.cycle(context.Background(), &s, start.Add(2*notReadyRetry)); !next.Equal(start.Add(interval)) {
+		t.Errorf("next cycle in %s, want %s", next.Sub(start), interval)
+	}
+	if got, want := read(nacos), "[\n  {\n    \"targets\": [\n      \"nacos:80\"\n    ]\n  }\n]\n"; got != want {
+		t.Fatalf("got output %q, want %q", got, want)
+	}
+
+	// all outputs are written on every interval
+	if next := w.cycle(context.Background(), &s, start.Add(interval)); !next.Equal(start.Add(2 * interval)) {
+		t.Errorf("next cycle in %s, want %s", next.Sub(start), 2*interval)
+	}
+	if refreshes["nacos"] != 4 || refreshes["http"] != 2 {
+		t.Errorf("got refreshes %v, want nacos 4 times and http twice", refreshes)
+	}
+}
@@ -0,0 +1,50 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// ansibleEncoder writes an ansible dynamic inventory, every target is a host
+// of group `all` named by its address, labels become its host variables.
+type ansibleEncoder struct{}
+
+func (ansibleEncoder) Name() string { return "ansible" }
+
+func (ansibleEncoder) ContentType() string { return "application/json; charset=utf-8" }
+
+func (ansibleEncoder) Encode(w io.Writer, tgs []*targetgroup.Group, pretty bool) error {
+	type group struct {
+		Hosts []string `json:"hosts"`
+	}
+	inventory := struct {
+		Meta struct {
+			HostVars map[string]model.LabelSet `json:"hostvars"`
+		} `json:"_meta"`
+		All group `json:"all"`
+	}{}
+	inventory.Meta.HostVars = map[string]model.LabelSet{}
+	inventory.All.Hosts = []string{}
+	for _, ls := range targets(tgs) {
+		host := string(ls[model.AddressLabel])
+		if _, ok := inventory.Meta.HostVars[host]; !ok {
+			inventory.All.Hosts = append(inventory.All.Hosts, host)
+		}
+		inventory.Meta.HostVars[host] = ls
+	}
+
+	encoder := json.NewEncoder(w)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(inventory)
+}
+
+func init() {
+	if err := Register("ansible", func() Encoder { return ansibleEncoder{} }); err != nil {
+		panic(err)
+	}
+}
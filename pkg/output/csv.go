@@ -0,0 +1,78 @@
+package output
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// csvEncoder writes one row per target, the address comes first followed by
+// the union of labels of all targets sorted by name.
+type csvEncoder struct {
+	name        string
+	contentType string
+	comma       rune
+}
+
+func (e csvEncoder) Name() string { return e.name }
+
+func (e csvEncoder) ContentType() string { return e.contentType }
+
+func (e csvEncoder) Encode(w io.Writer, tgs []*targetgroup.Group, _ bool) error {
+	rows := targets(tgs)
+	seen := map[model.LabelName]struct{}{}
+	var names []string
+	for _, row := range rows {
+		for name := range row {
+			if _, ok := seen[name]; !ok && name != model.AddressLabel {
+				seen[name] = struct{}{}
+				names = append(names, string(name))
+			}
+		}
+	}
+	sort.Strings(names)
+
+	cw := csv.NewWriter(w)
+	cw.Comma = e.comma
+	if err := cw.Write(append([]string{"address"}, names...)); err != nil {
+		return err
+	}
+	record := make([]string, len(names)+1)
+	for _, row := range rows {
+		record[0] = string(row[model.AddressLabel])
+		for i, name := range names {
+			record[i+1] = string(row[model.LabelName(name)])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// targets returns the labels of every target merged with the ones of its
+// group.
+func targets(tgs []*targetgroup.Group) []model.LabelSet {
+	var ret []model.LabelSet
+	for _, tg := range tgs {
+		for _, t := range tg.Targets {
+			ret = append(ret, tg.Labels.Merge(t))
+		}
+	}
+	return ret
+}
+
+func init() {
+	for _, e := range []csvEncoder{
+		{name: "csv", contentType: "text/csv; charset=utf-8", comma: ','},
+		{name: "tsv", contentType: "text/tab-separated-values; charset=utf-8", comma: '\t'},
+	} {
+		if err := Register(e.name, func() Encoder { return e }); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// jsonEncoder writes the http_sd format of prometheus, which is consumed by
+// vmagent and grafana alloy as well. Their formats are the same apart from
+// labels being always written, there are no limits specific to vmagent.
+type jsonEncoder struct {
+	name string
+	// labels are always written, even if empty
+	labels bool
+}
+
+func (e jsonEncoder) Name() string { return e.name }
+
+func (jsonEncoder) ContentType() string { return "application/json; charset=utf-8" }
+
+func (e jsonEncoder) Encode(w io.Writer, tgs []*targetgroup.Group, pretty bool) error {
+	encoder := json.NewEncoder(w)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	if !e.labels {
+		if tgs == nil {
+			tgs = []*targetgroup.Group{}
+		}
+		return encoder.Encode(tgs)
+	}
+	type group struct {
+		Targets []string       `json:"targets"`
+		Labels  model.LabelSet `json:"labels"`
+	}
+	groups := make([]group, 0, len(tgs))
+	for _, tg := range tgs {
+		g := group{Targets: make([]string, 0, len(tg.Targets)), Labels: tg.Labels}
+		if g.Labels == nil {
+			g.Labels = model.LabelSet{}
+		}
+		for _, t := range tg.Targets {
+			g.Targets = append(g.Targets, string(t[model.AddressLabel]))
+		}
+		groups = append(groups, g)
+	}
+	return encoder.Encode(groups)
+}
+
+func init() {
+	for _, e := range []jsonEncoder{
+		{name: Default},
+		{name: "vmagent", labels: true},
+		{name: "alloy", labels: true},
+	} {
+		if err := Register(e.name, func() Encoder { return e }); err != nil {
+			panic(err)
+		}
+	}
+}
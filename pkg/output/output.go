@@ -0,0 +1,122 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// Default is the format of prometheus http_sd, which is served unless
+// another one is requested.
+const Default = "prometheus"
+
+// Encoder writes targetgroups in a format for consumers of the targets
+// endpoint or file_sd outputs.
+type Encoder interface {
+	Name() string
+	// ContentType is the media type of the output, which is matched against
+	// the Accept header as well.
+	ContentType() string
+	// Encode writes tgs into w, pretty asks for a human readable output if
+	// the format supports it.
+	Encode(w io.Writer, tgs []*targetgroup.Group, pretty bool) error
+}
+
+type Factory func() Encoder
+
+var encoders = map[string]Factory{}
+
+func Register(name string, factory Factory) error {
+	if _, ok := encoders[name]; ok {
+		return fmt.Errorf("already registered encoder %s", name)
+	}
+	encoders[name] = factory
+	return nil
+}
+
+// Get returns a new instance of the named encoder, nil if it's unknown.
+func Get(name string) Encoder {
+	factory, ok := encoders[name]
+	if !ok {
+		return nil
+	}
+	return factory()
+}
+
+// Names returns the names of all registered encoders.
+func Names() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the encoder named by format if it's not empty, which takes
+// precedence over the Accept header.
+func Select(format, accept string) (Encoder, error) {
+	if format == "" {
+		return Negotiate(accept), nil
+	}
+	e := Get(format)
+	if e == nil {
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+	return e, nil
+}
+
+// Negotiate returns the encoder of the most preferred media type of the
+// Accept header, the default encoder is preferred among the ones sharing a
+// media type. It returns the default encoder if nothing acceptable is found.
+func Negotiate(accept string) Encoder {
+	type candidate struct {
+		mediaType string
+		q         float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	names := append([]string{Default}, Names()...)
+	for _, c := range candidates {
+		for _, name := range names {
+			e := Get(name)
+			if e != nil && matchMediaType(c.mediaType, e.ContentType()) {
+				return e
+			}
+		}
+	}
+	return Get(Default)
+}
+
+func matchMediaType(pattern, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
@@ -0,0 +1,154 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestNegotiate(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                 Default,
+		"*/*":                              Default,
+		"application/json":                 Default,
+		"application/*":                    Default,
+		"text/csv":                         "csv",
+		"text/*":                           "csv",
+		"text/tab-separated-values":        "tsv",
+		"application/yaml":                 "file_sd",
+		"text/csv;q=0.5, application/yaml": "file_sd",
+		"text/csv, application/yaml;q=0.9": "csv",
+		"text/csv;q=0":                     Default,
+		"text/csv;q=x, text/tab-separated-values": "tsv",
+		"image/png":        Default,
+		"not a media type": Default,
+	} {
+		if got := Negotiate(accept).Name(); got != want {
+			t.Errorf("Negotiate(%q) = %s, want %s", accept, got, want)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	for _, tc := range []struct {
+		format, accept string
+		want           string
+		err            bool
+	}{
+		{format: "", accept: "text/csv", want: "csv"},
+		{format: "ansible", accept: "text/csv", want: "ansible"},
+		{format: "vmagent", accept: "", want: "vmagent"},
+		{format: "nope", accept: "text/csv", err: true},
+	} {
+		e, err := Select(tc.format, tc.accept)
+		if tc.err {
+			if err == nil {
+				t.Errorf("Select(%q, %q) = %s, want error", tc.format, tc.accept, e.Name())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Select(%q, %q): %v", tc.format, tc.accept, err)
+			continue
+		}
+		if e.Name() != tc.want {
+			t.Errorf("Select(%q, %q) = %s, want %s", tc.format, tc.accept, e.Name(), tc.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tgs := []*targetgroup.Group{
+		{
+			Source: "a",
+			Labels: model.LabelSet{"job": "api"},
+			Targets: []model.LabelSet{
+				{model.AddressLabel: "10.0.0.1:80", "zone": "a,b"},
+				{model.AddressLabel: "10.0.0.2:80"},
+			},
+		},
+		{Targets: []model.LabelSet{{model.AddressLabel: "10.0.0.3:80", "note": "say \"hi\"\tthere"}}},
+		{Labels: model.LabelSet{"job": "db"}, Targets: []model.LabelSet{{model.AddressLabel: "10.0.0.1:80"}}},
+	}
+	for _, tc := range []struct {
+		format string
+		tgs    []*targetgroup.Group
+		want   string
+	}{
+		{
+			format: "prometheus",
+			tgs:    tgs,
+			want:   `[{"targets":["10.0.0.1:80","10.0.0.2:80"],"labels":{"job":"api"}},{"targets":["10.0.0.3:80"]},{"targets":["10.0.0.1:80"],"labels":{"job":"db"}}]` + "\n",
+		},
+		{
+			format: "prometheus",
+			want:   "[]\n",
+		},
+		{
+			format: "vmagent",
+			tgs:    tgs,
+			want:   `[{"targets":["10.0.0.1:80","10.0.0.2:80"],"labels":{"job":"api"}},{"targets":["10.0.0.3:80"],"labels":{}},{"targets":["10.0.0.1:80"],"labels":{"job":"db"}}]` + "\n",
+		},
+		{
+			format: "alloy",
+			want:   "[]\n",
+		},
+		{
+			format: "ansible",
+			tgs:    tgs,
+			want: `{"_meta":{"hostvars":{` +
+				`"10.0.0.1:80":{"__address__":"10.0.0.1:80","job":"db"},` +
+				`"10.0.0.2:80":{"__address__":"10.0.0.2:80","job":"api"},` +
+				`"10.0.0.3:80":{"__address__":"10.0.0.3:80","note":"say \"hi\"\tthere"}}},` +
+				`"all":{"hosts":["10.0.0.1:80","10.0.0.2:80","10.0.0.3:80"]}}` + "\n",
+		},
+		{
+			format: "ansible",
+			want:   `{"_meta":{"hostvars":{}},"all":{"hosts":[]}}` + "\n",
+		},
+		{
+			format: "csv",
+			tgs:    tgs,
+			want: "address,job,note,zone\n" +
+				"10.0.0.1:80,api,,\"a,b\"\n" +
+				"10.0.0.2:80,api,,\n" +
+				"10.0.0.3:80,,\"say \"\"hi\"\"\tthere\",\n" +
+				"10.0.0.1:80,db,,\n",
+		},
+		{
+			format: "tsv",
+			tgs:    tgs,
+			want: "address\tjob\tnote\tzone\n" +
+				"10.0.0.1:80\tapi\t\ta,b\n" +
+				"10.0.0.2:80\tapi\t\t\n" +
+				"10.0.0.3:80\t\t\"say \"\"hi\"\"\tthere\"\t\n" +
+				"10.0.0.1:80\tdb\t\t\n",
+		},
+		{
+			format: "csv",
+			want:   "address\n",
+		},
+		{
+			format: "file_sd",
+			tgs:    tgs,
+			want: "- targets:\n  - 10.0.0.1:80\n  - 10.0.0.2:80\n  labels:\n    job: api\n" +
+				"- targets:\n  - 10.0.0.3:80\n" +
+				"- targets:\n  - 10.0.0.1:80\n  labels:\n    job: db\n",
+		},
+		{
+			format: "file_sd",
+			want:   "[]\n",
+		},
+	} {
+		var b bytes.Buffer
+		if err := Get(tc.format).Encode(&b, tc.tgs, false); err != nil {
+			t.Errorf("%s: %v", tc.format, err)
+			continue
+		}
+		if got := b.String(); got != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.format, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package output
+
+import (
+	"io"
+
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"gopkg.in/yaml.v2"
+)
+
+// yamlEncoder writes the file_sd format of prometheus in YAML.
+type yamlEncoder struct{}
+
+func (yamlEncoder) Name() string { return "file_sd" }
+
+func (yamlEncoder) ContentType() string { return "application/yaml; charset=utf-8" }
+
+func (yamlEncoder) Encode(w io.Writer, tgs []*targetgroup.Group, _ bool) error {
+	if tgs == nil {
+		tgs = []*targetgroup.Group{}
+	}
+	b, err := yaml.Marshal(tgs)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func init() {
+	if err := Register("file_sd", func() Encoder { return yamlEncoder{} }); err != nil {
+		panic(err)
+	}
+}
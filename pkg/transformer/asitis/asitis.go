@@ -51,6 +51,8 @@ func (asitis) TargetURL(base string, q url.Values) (string, error) {
 
 func (asitis) HTTPMethod() string { return http.MethodGet }
 
+func (asitis) HTTPBody(_ url.Values) ([]byte, error) { return nil, nil }
+
 // Transform unmarshal response body into array of targetgroup.Group
 func (a *asitis) Transform(_ context.Context, b []byte) ([]*targetgroup.Group, error) {
 	if a.t == nil || (a.t.GoTemplate == "" && a.t.JSONPath == "") {
@@ -72,7 +74,7 @@ func (a *asitis) Transform(_ context.Context, b []byte) ([]*targetgroup.Group, e
 }
 
 func init() {
-	if err := transformer.Register(&asitis{}); err != nil {
+	if err := transformer.Register(name, func() transformer.Transformer { return &asitis{} }); err != nil {
 		panic(err)
 	}
 }
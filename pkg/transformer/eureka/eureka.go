@@ -0,0 +1,273 @@
+package eureka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/regexp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/fengxsong/httpsd/pkg/transformer"
+	"github.com/fengxsong/httpsd/pkg/utils"
+)
+
+const name = "eureka"
+
+var matchContentType = regexp.MustCompile(`^(?i:(application|text)\/(json|xml)(;\s*charset=("utf-8"|utf-8))?)$`)
+
+type impl struct{}
+
+func (impl) Name() string { return name }
+
+func (impl) SampleConfig() transformer.Config {
+	return nil
+}
+
+func (impl) Init(_ transformer.Config) error { return nil }
+
+// TargetURL returns the url of all applications, or of the one given by the
+// `app` query parameter. The `/eureka` context path is appended to base
+// unless it's already one of its path segments, eg. `/eureka/v2`.
+func (impl) TargetURL(base string, q url.Values) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	p := strings.TrimSuffix(u.EscapedPath(), "/")
+	if !slices.Contains(strings.Split(p, "/"), "eureka") {
+		p += "/eureka"
+	}
+	p += "/apps"
+	if app := q.Get("app"); app != "" {
+		p += "/" + url.PathEscape(app)
+	}
+	if u.Path, err = url.PathUnescape(p); err != nil {
+		return "", err
+	}
+	u.RawPath = p
+	return u.String(), nil
+}
+
+func (impl) HTTPMethod() string { return http.MethodGet }
+
+func (impl) HTTPBody(_ url.Values) ([]byte, error) { return nil, nil }
+
+// MatchContentType implements transformer.ContentTypeMatcher.
+func (impl) MatchContentType(contentType string) bool {
+	return matchContentType.MatchString(contentType)
+}
+
+// Transform parses the JSON or XML response of eureka, only instances in
+// statuses given by the `status` query parameters are kept if any.
+func (impl) Transform(ctx context.Context, b []byte) ([]*targetgroup.Group, error) {
+	apps, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	statuses := map[string]struct{}{}
+	for _, v := range transformer.QueryFromContext(ctx)["status"] {
+		for _, status := range strings.Split(v, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				statuses[strings.ToUpper(status)] = struct{}{}
+			}
+		}
+	}
+	var targetGroups []*targetgroup.Group
+	for _, app := range apps {
+		for _, instance := range app.Instances {
+			if _, ok := statuses[instance.Status]; len(statuses) > 0 && !ok {
+				continue
+			}
+			targetGroups = append(targetGroups, transform(app.Name, instance))
+		}
+	}
+	return targetGroups, nil
+}
+
+// parse returns the applications of either /eureka/apps or /eureka/apps/{app}.
+func parse(b []byte) ([]application, error) {
+	b = bytes.TrimSpace(b)
+	if bytes.HasPrefix(b, []byte("<")) {
+		var root struct {
+			XMLName      xml.Name
+			Applications []application `xml:"application"`
+			application
+		}
+		if err := xml.Unmarshal(b, &root); err != nil {
+			return nil, err
+		}
+		if root.XMLName.Local == "application" {
+			return []application{root.application}, nil
+		}
+		return root.Applications, nil
+	}
+	var root struct {
+		Applications *struct {
+			Applications list[application] `json:"application"`
+		} `json:"applications"`
+		Application *application `json:"application"`
+	}
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	if root.Application != nil {
+		return []application{*root.Application}, nil
+	}
+	if root.Applications != nil {
+		return root.Applications.Applications, nil
+	}
+	return nil, nil
+}
+
+// list unmarshals a JSON array, or a single object which eureka returns
+// when there's only one element.
+type list[T any] []T
+
+func (l *list[T]) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); bytes.HasPrefix(b, []byte("{")) {
+		var v T
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*l = list[T]{v}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]T)(l))
+}
+
+type application struct {
+	Name      string         `json:"name" xml:"name"`
+	Instances list[instance] `json:"instance" xml:"instance"`
+}
+
+type port struct {
+	Port    int  `json:"$" xml:",chardata"`
+	Enabled bool `json:"@enabled,string" xml:"enabled,attr"`
+}
+
+type dataCenterInfo struct {
+	Name     string   `json:"name" xml:"name"`
+	Metadata metadata `json:"metadata" xml:"metadata"`
+}
+
+type instance struct {
+	InstanceID       string          `json:"instanceId" xml:"instanceId"`
+	HostName         string          `json:"hostName" xml:"hostName"`
+	App              string          `json:"app" xml:"app"`
+	IPAddr           string          `json:"ipAddr" xml:"ipAddr"`
+	VipAddress       string          `json:"vipAddress" xml:"vipAddress"`
+	SecureVipAddress string          `json:"secureVipAddress" xml:"secureVipAddress"`
+	Status           string          `json:"status" xml:"status"`
+	Port             *port           `json:"port" xml:"port"`
+	SecurePort       *port           `json:"securePort" xml:"securePort"`
+	HomePageURL      string          `json:"homePageUrl" xml:"homePageUrl"`
+	StatusPageURL    string          `json:"statusPageUrl" xml:"statusPageUrl"`
+	HealthCheckURL   string          `json:"healthCheckUrl" xml:"healthCheckUrl"`
+	CountryID        int             `json:"countryId" xml:"countryId"`
+	DataCenterInfo   *dataCenterInfo `json:"dataCenterInfo" xml:"dataCenterInfo"`
+	Metadata         metadata        `json:"metadata" xml:"metadata"`
+}
+
+// metadata is a map in JSON, while a list of arbitrary elements in XML.
+type metadata map[string]string
+
+// UnmarshalJSON drops the type hints of jackson, eg. `"@class":
+// "java.util.Collections$EmptyMap"`, which aren't metadata.
+func (m *metadata) UnmarshalJSON(b []byte) error {
+	var v map[string]string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*m = metadata{}
+	for k, value := range v {
+		if !strings.HasPrefix(k, "@") {
+			(*m)[k] = value
+		}
+	}
+	return nil
+}
+
+func (m *metadata) UnmarshalXML(d *xml.Decoder, _ xml.StartElement) error {
+	*m = metadata{}
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			var v string
+			if err = d.DecodeElement(&v, &t); err != nil {
+				return err
+			}
+			(*m)[t.Name.Local] = v
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// transform converts an instance into a targetgroup, with labels compatible
+// with prometheus eureka sd.
+func transform(app string, instance instance) *targetgroup.Group {
+	addr := instance.HostName
+	if instance.Port != nil {
+		addr = net.JoinHostPort(instance.HostName, strconv.Itoa(instance.Port.Port))
+	}
+	g := &targetgroup.Group{
+		Targets: []model.LabelSet{
+			{model.AddressLabel: model.LabelValue(addr)},
+		},
+		Labels: model.LabelSet{
+			labelName("app_name"):                        model.LabelValue(app),
+			labelName("app_instance_hostname"):           model.LabelValue(instance.HostName),
+			labelName("app_instance_homepage_url"):       model.LabelValue(instance.HomePageURL),
+			labelName("app_instance_statuspage_url"):     model.LabelValue(instance.StatusPageURL),
+			labelName("app_instance_healthcheck_url"):    model.LabelValue(instance.HealthCheckURL),
+			labelName("app_instance_ip_addr"):            model.LabelValue(instance.IPAddr),
+			labelName("app_instance_vip_address"):        model.LabelValue(instance.VipAddress),
+			labelName("app_instance_secure_vip_address"): model.LabelValue(instance.SecureVipAddress),
+			labelName("app_instance_status"):             model.LabelValue(instance.Status),
+			labelName("app_instance_country_id"):         model.LabelValue(strconv.Itoa(instance.CountryID)),
+			labelName("app_instance_id"):                 model.LabelValue(instance.InstanceID),
+		},
+	}
+	if instance.Port != nil {
+		g.Labels[labelName("app_instance_port")] = model.LabelValue(strconv.Itoa(instance.Port.Port))
+		g.Labels[labelName("app_instance_port_enabled")] = model.LabelValue(strconv.FormatBool(instance.Port.Enabled))
+	}
+	if instance.SecurePort != nil {
+		g.Labels[labelName("app_instance_secure_port")] = model.LabelValue(strconv.Itoa(instance.SecurePort.Port))
+		g.Labels[labelName("app_instance_secure_port_enabled")] = model.LabelValue(strconv.FormatBool(instance.SecurePort.Enabled))
+	}
+	if instance.DataCenterInfo != nil {
+		g.Labels[labelName("app_instance_datacenterinfo_name")] = model.LabelValue(instance.DataCenterInfo.Name)
+		for k, v := range instance.DataCenterInfo.Metadata {
+			g.Labels[labelName("app_instance_datacenterinfo_metadata_"+k)] = model.LabelValue(v)
+		}
+	}
+	for k, v := range instance.Metadata {
+		g.Labels[labelName("app_instance_metadata_"+k)] = model.LabelValue(v)
+	}
+	return g
+}
+
+func labelName(k string) model.LabelName {
+	return model.LabelName(fmt.Sprintf("%s%s_%s", model.MetaLabelPrefix, name, utils.FormalizeLabelName(k)))
+}
+
+func init() {
+	if err := transformer.Register(name, func() transformer.Transformer { return &impl{} }); err != nil {
+		panic(err)
+	}
+}
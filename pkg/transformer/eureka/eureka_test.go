@@ -0,0 +1,150 @@
+package eureka
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/fengxsong/httpsd/pkg/transformer"
+)
+
+func TestTransformApps(t *testing.T) {
+	b, err := os.ReadFile("testdata/apps.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		q       url.Values
+		targets []string
+	}{
+		{name: "all", q: url.Values{}, targets: []string{"10.0.0.1:8080", "user-1.example.com:9090"}},
+		{name: "status", q: url.Values{"status": {"up"}}, targets: []string{"10.0.0.1:8080"}},
+		{name: "statuses", q: url.Values{"status": {"UP,DOWN"}}, targets: []string{"10.0.0.1:8080", "user-1.example.com:9090"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tgs, err := impl{}.Transform(transformer.WithQuery(context.Background(), tc.q), b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var targets []string
+			for _, tg := range tgs {
+				for name := range tg.Labels {
+					if !name.IsValid() {
+						t.Errorf("invalid label name %q", name)
+					}
+				}
+				for _, target := range tg.Targets {
+					targets = append(targets, string(target[model.AddressLabel]))
+				}
+			}
+			if !reflect.DeepEqual(targets, tc.targets) {
+				t.Errorf("targets = %v, want %v", targets, tc.targets)
+			}
+		})
+	}
+}
+
+func TestTransformMetadata(t *testing.T) {
+	b, err := os.ReadFile("testdata/apps.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgs, err := impl{}.Transform(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tgs) != 2 {
+		t.Fatalf("got %d targetgroups, want 2", len(tgs))
+	}
+	for name := range tgs[0].Labels {
+		if name == "__meta_eureka_app_instance_metadata__class" {
+			t.Errorf("type hint of jackson kept as %s", name)
+		}
+	}
+	want := model.LabelSet{
+		"__meta_eureka_app_name":                              "USER-SERVICE",
+		"__meta_eureka_app_instance_hostname":                 "user-1.example.com",
+		"__meta_eureka_app_instance_homepage_url":             "",
+		"__meta_eureka_app_instance_statuspage_url":           "",
+		"__meta_eureka_app_instance_healthcheck_url":          "",
+		"__meta_eureka_app_instance_ip_addr":                  "10.0.0.2",
+		"__meta_eureka_app_instance_vip_address":              "user-service",
+		"__meta_eureka_app_instance_secure_vip_address":       "user-service",
+		"__meta_eureka_app_instance_status":                   "DOWN",
+		"__meta_eureka_app_instance_country_id":               "1",
+		"__meta_eureka_app_instance_id":                       "user-1",
+		"__meta_eureka_app_instance_port":                     "9090",
+		"__meta_eureka_app_instance_port_enabled":             "true",
+		"__meta_eureka_app_instance_secure_port":              "443",
+		"__meta_eureka_app_instance_secure_port_enabled":      "false",
+		"__meta_eureka_app_instance_datacenterinfo_name":      "MyOwn",
+		"__meta_eureka_app_instance_metadata_management_port": "9091",
+		"__meta_eureka_app_instance_metadata_zone_name":       "zone1",
+		"__meta_eureka_app_instance_metadata_git_commit":      "abc123",
+	}
+	if !reflect.DeepEqual(tgs[1].Labels, want) {
+		t.Errorf("labels = %v, want %v", tgs[1].Labels, want)
+	}
+}
+
+func TestTransformXML(t *testing.T) {
+	b := []byte(`<application>
+  <name>PAY</name>
+  <instance>
+    <instanceId>p1</instanceId>
+    <hostName>p1</hostName>
+    <status>UP</status>
+    <port enabled="true">9000</port>
+    <metadata><zone>z1</zone><my.key>v</my.key></metadata>
+    <dataCenterInfo class="com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo"><name>MyOwn</name></dataCenterInfo>
+  </instance>
+</application>`)
+	tgs, err := impl{}.Transform(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tgs) != 1 || tgs[0].Targets[0][model.AddressLabel] != "p1:9000" {
+		t.Fatalf("unexpected targetgroups %v", tgs)
+	}
+	for k, v := range map[model.LabelName]model.LabelValue{
+		"__meta_eureka_app_name":                     "PAY",
+		"__meta_eureka_app_instance_metadata_zone":   "z1",
+		"__meta_eureka_app_instance_metadata_my_key": "v",
+	} {
+		if got := tgs[0].Labels[k]; got != v {
+			t.Errorf("label %s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestTargetURL(t *testing.T) {
+	for _, tc := range []struct {
+		base string
+		q    url.Values
+		want string
+	}{
+		{base: "http://eureka:8761", want: "http://eureka:8761/eureka/apps"},
+		{base: "http://eureka:8761/", want: "http://eureka:8761/eureka/apps"},
+		{base: "http://eureka:8761/eureka", want: "http://eureka:8761/eureka/apps"},
+		{base: "http://eureka:8761/eureka/", want: "http://eureka:8761/eureka/apps"},
+		{base: "http://eureka:8080/eureka/v2", want: "http://eureka:8080/eureka/v2/apps"},
+		{base: "http://gw/registry/eureka/v2/", want: "http://gw/registry/eureka/v2/apps"},
+		{base: "http://gw/registry", want: "http://gw/registry/eureka/apps"},
+		{base: "http://gw/myeureka", want: "http://gw/myeureka/eureka/apps"},
+		{base: "http://eureka:8761", q: url.Values{"app": {"USER SERVICE"}}, want: "http://eureka:8761/eureka/apps/USER%20SERVICE"},
+		{base: "http://eureka:8080/eureka/v2", q: url.Values{"app": {"a/b"}}, want: "http://eureka:8080/eureka/v2/apps/a%2Fb"},
+	} {
+		got, err := impl{}.TargetURL(tc.base, tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("TargetURL(%q, %v) = %s, want %s", tc.base, tc.q, got, tc.want)
+		}
+	}
+}
@@ -3,9 +3,7 @@ package nacos
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -19,7 +17,10 @@ import (
 	"github.com/fengxsong/httpsd/pkg/utils"
 )
 
-const name = "nacos"
+const (
+	name            = "nacos"
+	publicNamespace = "public"
+)
 
 type impl struct{}
 
@@ -34,7 +35,7 @@ func (impl) Init(_ transformer.Config) error { return nil }
 func (impl) TargetURL(base string, q url.Values) (string, error) {
 	serviceName := q.Get("serviceName")
 	if serviceName == "" {
-		return "", errors.New("serviceName is required")
+		return "", fmt.Errorf("%w: serviceName is required", transformer.ErrInvalidQuery)
 	}
 	qs := url.Values{}
 	qs.Set("serviceName", serviceName)
@@ -45,19 +46,96 @@ func (impl) TargetURL(base string, q url.Values) (string, error) {
 	return fmt.Sprintf("%s/nacos/v1/ns/instance/list?%s", base, qs.Encode()), nil
 }
 
-func (impl) HTTPBody() io.Reader { return nil }
+func (impl) HTTPBody(_ url.Values) ([]byte, error) { return nil, nil }
 
 func (impl) HTTPMethod() string { return http.MethodGet }
 
-func (impl) Transform(_ context.Context, b []byte) ([]*targetgroup.Group, error) {
+func (impl) Transform(ctx context.Context, b []byte) ([]*targetgroup.Group, error) {
 	var instances nacosmodel.Service
 	if err := json.Unmarshal(b, &instances); err != nil {
 		return nil, err
 	}
-	return Transform(instances)
+	q := transformer.QueryFromContext(ctx)
+	filter, err := ParseFilter(q)
+	if err != nil {
+		return nil, err
+	}
+	tgs, err := Transform(instances, q.Get("namespaceId"))
+	if err != nil {
+		return nil, err
+	}
+	return filter.Filter(tgs), nil
+}
+
+// Filter drops instances by their health, enabled state and weight.
+type Filter struct {
+	HealthyOnly bool
+	EnabledOnly bool
+	MinWeight   float64
+}
+
+// ParseFilter parses `healthyOnly`, `enabledOnly` and `minWeight` query
+// parameters.
+func ParseFilter(q url.Values) (Filter, error) {
+	var (
+		f   Filter
+		err error
+	)
+	if v := q.Get("healthyOnly"); v != "" {
+		if f.HealthyOnly, err = strconv.ParseBool(v); err != nil {
+			return f, fmt.Errorf("%w: invalid healthyOnly %q: %v", transformer.ErrInvalidQuery, v, err)
+		}
+	}
+	if v := q.Get("enabledOnly"); v != "" {
+		if f.EnabledOnly, err = strconv.ParseBool(v); err != nil {
+			return f, fmt.Errorf("%w: invalid enabledOnly %q: %v", transformer.ErrInvalidQuery, v, err)
+		}
+	}
+	if v := q.Get("minWeight"); v != "" {
+		if f.MinWeight, err = strconv.ParseFloat(v, 64); err != nil {
+			return f, fmt.Errorf("%w: invalid minWeight %q: %v", transformer.ErrInvalidQuery, v, err)
+		}
+	}
+	return f, nil
+}
+
+// Filter returns targetgroups matching f, it works on the labels so that
+// cached targetgroups can be filtered as well.
+func (f Filter) Filter(tgs []*targetgroup.Group) []*targetgroup.Group {
+	if f == (Filter{}) {
+		return tgs
+	}
+	ret := make([]*targetgroup.Group, 0, len(tgs))
+	for _, tg := range tgs {
+		if f.HealthyOnly && tg.Labels[labelName("healthy")] != "true" {
+			continue
+		}
+		if f.EnabledOnly && tg.Labels[labelName("enabled")] != "true" {
+			continue
+		}
+		if f.MinWeight > 0 {
+			weight, err := strconv.ParseFloat(string(tg.Labels[labelName("weight")]), 64)
+			if err != nil || weight < f.MinWeight {
+				continue
+			}
+		}
+		ret = append(ret, tg)
+	}
+	return ret
+}
+
+// NamespaceID returns the id of namespace shown in labels, an empty
+// namespace stands for the public one.
+func NamespaceID(namespace string) string {
+	if namespace == "" {
+		return publicNamespace
+	}
+	return namespace
 }
 
-func Transform(service nacosmodel.Service) ([]*targetgroup.Group, error) {
+// Transform converts instances of service into targetgroups.
+func Transform(service nacosmodel.Service, namespace string) ([]*targetgroup.Group, error) {
+	namespace = NamespaceID(namespace)
 	var targetGroups []*targetgroup.Group
 	for _, instance := range service.Hosts {
 		g := &targetgroup.Group{
@@ -65,9 +143,15 @@ func Transform(service nacosmodel.Service) ([]*targetgroup.Group, error) {
 				{model.AddressLabel: model.LabelValue(net.JoinHostPort(instance.Ip, strconv.Itoa(int(instance.Port))))},
 			},
 			Labels: model.LabelSet{
-				labelName("cluster"): model.LabelValue(instance.ClusterName),
-				labelName("service"): model.LabelValue(instance.ServiceName),
-				labelName("group"):   model.LabelValue(service.GroupName),
+				labelName("cluster"):     model.LabelValue(instance.ClusterName),
+				labelName("service"):     model.LabelValue(instance.ServiceName),
+				labelName("group"):       model.LabelValue(service.GroupName),
+				labelName("namespace"):   model.LabelValue(namespace),
+				labelName("instance_id"): model.LabelValue(instance.InstanceId),
+				labelName("healthy"):     model.LabelValue(strconv.FormatBool(instance.Healthy)),
+				labelName("enabled"):     model.LabelValue(strconv.FormatBool(instance.Enable)),
+				labelName("ephemeral"):   model.LabelValue(strconv.FormatBool(instance.Ephemeral)),
+				labelName("weight"):      model.LabelValue(strconv.FormatFloat(instance.Weight, 'f', -1, 64)),
 			},
 		}
 		for k, v := range instance.Metadata {
@@ -87,7 +171,7 @@ func labelName(k string, args ...string) model.LabelName {
 }
 
 func init() {
-	if err := transformer.Register(&impl{}); err != nil {
+	if err := transformer.Register(name, func() transformer.Transformer { return &impl{} }); err != nil {
 		panic(err)
 	}
 }
@@ -3,9 +3,13 @@ package transformer
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
+	"net/http"
 	"net/url"
+	"strings"
+	texttemplate "text/template"
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
@@ -24,27 +28,134 @@ type Template struct {
 	JSONPath   string `yaml:"jsonpath,omitempty"`
 }
 
+// Validate parses the go template or the JSONPath expression without
+// executing it.
+func (t *Template) Validate() error {
+	if t.GoTemplate != "" {
+		_, err := t.goTemplate()
+		return err
+	}
+	if t.JSONPath != "" {
+		_, err := t.jsonPath()
+		return err
+	}
+	return nil
+}
+
+func (t *Template) goTemplate() (*template.Template, error) {
+	tpl, err := defaultTpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return tpl.Parse(t.GoTemplate)
+}
+
+func (t *Template) jsonPath() (*jsonpath.JSONPath, error) {
+	jpath := jsonpath.New("t")
+	if err := jpath.Parse(t.JSONPath); err != nil {
+		return nil, err
+	}
+	return jpath, nil
+}
+
 func (t *Template) Execute(data any) ([]byte, error) {
 	out := bytes.NewBuffer([]byte{})
-	var err error
 	if t.GoTemplate != "" {
-		tpl, err := defaultTpl.Clone()
+		tpl, err := t.goTemplate()
 		if err != nil {
 			return nil, err
 		}
-		tpl, err = tpl.Parse(t.GoTemplate)
-		if err != nil {
+		if err = tpl.Execute(out, data); err != nil {
 			return nil, err
 		}
-		err = tpl.Execute(out, data)
 	} else if t.JSONPath != "" {
-		jpath := jsonpath.New("t")
-		if err := jpath.Parse(t.JSONPath); err != nil {
+		jpath, err := t.jsonPath()
+		if err != nil {
+			return nil, err
+		}
+		if err = jpath.Execute(out, data); err != nil {
 			return nil, err
 		}
-		err = jpath.Execute(out, data)
 	}
-	return out.Bytes(), err
+	return out.Bytes(), nil
+}
+
+// ErrInvalidMethod is returned by Request.Validate if Method isn't a valid
+// http method.
+var ErrInvalidMethod = errors.New("invalid method")
+
+// Request customizes the requests to upstream, the method and body of the
+// transformer are overridden if set. Other headers are set by the
+// http_headers of the http client config, which supports secrets.
+type Request struct {
+	Method string `yaml:"method,omitempty"`
+	// Body is a go template rendered with the query parameters, the first
+	// value of every parameter is accessible by its name, eg. {{ .serviceName }}.
+	// Values are NOT escaped whatever the content type is, so they must be
+	// piped to toJson in JSON bodies, eg. {"env": {{ .env | toJson }}},
+	// otherwise a query parameter holding quotes changes the body.
+	Body string `yaml:"body,omitempty"`
+	// ContentType of the body, application/json by default.
+	ContentType string `yaml:"content_type,omitempty"`
+}
+
+// Validate parses the body template without executing it.
+func (r *Request) Validate() error {
+	if r.Method != "" && !isToken(r.Method) {
+		return fmt.Errorf("%w %q", ErrInvalidMethod, r.Method)
+	}
+	_, err := r.bodyTemplate()
+	return err
+}
+
+// isToken reports whether s is a token of RFC 7230, which methods are.
+func isToken(s string) bool {
+	for _, c := range s {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Request) bodyTemplate() (*texttemplate.Template, error) {
+	return texttemplate.New("body").Option("missingkey=zero").Funcs(sprig.TxtFuncMap()).Parse(r.Body)
+}
+
+// HTTPMethod returns Method, or POST if only Body is set, or fallback.
+func (r *Request) HTTPMethod(fallback string) string {
+	switch {
+	case r.Method != "":
+		return strings.ToUpper(r.Method)
+	case r.Body != "":
+		return http.MethodPost
+	default:
+		return fallback
+	}
+}
+
+// HTTPBody renders Body with the query parameters, nil is returned if
+// there's no Body.
+func (r *Request) HTTPBody(q url.Values) ([]byte, error) {
+	if r.Body == "" {
+		return nil, nil
+	}
+	tpl, err := r.bodyTemplate()
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]string, len(q))
+	for k := range q {
+		data[k] = q.Get(k)
+	}
+	var out bytes.Buffer
+	if err = tpl.Execute(&out, data); err != nil {
+		return nil, fmt.Errorf("failed to render body: %w", err)
+	}
+	return out.Bytes(), nil
 }
 
 type Config any
@@ -55,19 +166,56 @@ type Transformer interface {
 	Init(Config) error
 	TargetURL(string, url.Values) (string, error)
 	HTTPMethod() string
+	// HTTPBody returns the body of the request for the query, nil if none.
+	HTTPBody(url.Values) ([]byte, error)
 	Transform(context.Context, []byte) ([]*targetgroup.Group, error)
 }
 
-var transformers = map[string]Transformer{}
+// ContentTypeMatcher can be implemented by transformers accepting response
+// bodies other than JSON.
+type ContentTypeMatcher interface {
+	MatchContentType(string) bool
+}
+
+type queryKey struct{}
+
+// WithQuery returns a copy of ctx carrying the query of the request, so that
+// transformers can filter on parameters not sent upstream in Transform.
+func WithQuery(ctx context.Context, q url.Values) context.Context {
+	return context.WithValue(ctx, queryKey{}, q)
+}
+
+// QueryFromContext returns the query of the request carried by ctx.
+func QueryFromContext(ctx context.Context) url.Values {
+	q, _ := ctx.Value(queryKey{}).(url.Values)
+	if q == nil {
+		return url.Values{}
+	}
+	return q
+}
+
+// ErrInvalidQuery is wrapped by errors of transformers about a query missing
+// or having invalid parameters.
+var ErrInvalidQuery = errors.New("invalid query")
 
-func Register(t Transformer) error {
-	if _, ok := transformers[t.Name()]; ok {
-		return fmt.Errorf("already registered transformer %s", t.Name())
+// Factory creates a new, uninitialized Transformer.
+type Factory func() Transformer
+
+var transformers = map[string]Factory{}
+
+func Register(name string, factory Factory) error {
+	if _, ok := transformers[name]; ok {
+		return fmt.Errorf("already registered transformer %s", name)
 	}
-	transformers[t.Name()] = t
+	transformers[name] = factory
 	return nil
 }
 
+// Get returns a new instance of the named transformer, nil if it's unknown.
 func Get(name string) Transformer {
-	return transformers[name]
+	factory, ok := transformers[name]
+	if !ok {
+		return nil
+	}
+	return factory()
 }
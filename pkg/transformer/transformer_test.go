@@ -0,0 +1,62 @@
+package transformer
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		r      Request
+		q      url.Values
+		method string
+		body   string
+	}{
+		{name: "transformer defaults", r: Request{}, method: http.MethodGet},
+		{name: "body", r: Request{Body: `{"env": {{ .env | toJson }}}`}, q: url.Values{"env": {"prod", "dev"}}, method: http.MethodPost, body: `{"env": "prod"}`},
+		{name: "missing parameter", r: Request{Body: `{"env": "{{ .env }}"}`}, method: http.MethodPost, body: `{"env": ""}`},
+		{name: "default", r: Request{Body: `{{ .env | default "prod" }}`}, method: http.MethodPost, body: `prod`},
+		{name: "method", r: Request{Method: "put", Body: `{}`}, method: http.MethodPut, body: `{}`},
+		{name: "method without body", r: Request{Method: "POST"}, method: http.MethodPost},
+		{name: "extension method", r: Request{Method: "PROPFIND"}, method: "PROPFIND"},
+		{name: "values aren't escaped", r: Request{Body: `{"env": "{{ .env }}"}`}, q: url.Values{"env": {`a", "admin": "true`}}, method: http.MethodPost, body: `{"env": "a", "admin": "true"}`},
+		{name: "toJson escapes values", r: Request{Body: `{"env": {{ .env | toJson }}}`}, q: url.Values{"env": {`a", "admin": "true`}}, method: http.MethodPost, body: `{"env": "a\", \"admin\": \"true"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.r.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tc.r.HTTPMethod(http.MethodGet); got != tc.method {
+				t.Errorf("method = %s, want %s", got, tc.method)
+			}
+			body, err := tc.r.HTTPBody(tc.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tc.body {
+				t.Errorf("body = %q, want %q", body, tc.body)
+			}
+			if tc.body == "" && body != nil {
+				t.Errorf("body = %q, want nil", body)
+			}
+		})
+	}
+}
+
+func TestRequestValidate(t *testing.T) {
+	for _, r := range []Request{
+		{Method: "GE T"},
+		{Method: "get\x00"},
+		{Method: "GET/1"},
+		{Method: "PÖST"},
+		{Method: `"GET"`},
+		{Body: `{{ .env `},
+		{Body: `{{ unknownFunc .env }}`},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("%+v accepted", r)
+		}
+	}
+}
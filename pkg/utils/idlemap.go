@@ -0,0 +1,78 @@
+package utils
+
+import "time"
+
+// idleSweepInterval is the minimum interval between sweeps of idle entries.
+const idleSweepInterval = time.Minute
+
+type idleEntry[V any] struct {
+	v          V
+	lastAccess time.Time
+}
+
+// IdleMap is a map bounded in both time and size: entries not accessed for
+// longer than idle are evicted, and the least recently accessed one is
+// evicted first when adding to a full map. It's not safe for concurrent use.
+type IdleMap[V any] struct {
+	idle  time.Duration
+	max   int
+	m     map[string]*idleEntry[V]
+	swept time.Time
+}
+
+func NewIdleMap[V any](idle time.Duration, max int) *IdleMap[V] {
+	return &IdleMap[V]{idle: idle, max: max, m: map[string]*idleEntry[V]{}}
+}
+
+// Get returns the value of key and marks it accessed at now.
+func (m *IdleMap[V]) Get(key string, now time.Time) (V, bool) {
+	e, ok := m.m[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e.lastAccess = now
+	return e.v, true
+}
+
+// Set stores v for key accessed at now, evicting entries first if key is new.
+func (m *IdleMap[V]) Set(key string, v V, now time.Time) {
+	if _, ok := m.m[key]; !ok {
+		m.evict(now)
+	}
+	m.m[key] = &idleEntry[V]{v: v, lastAccess: now}
+}
+
+func (m *IdleMap[V]) Delete(key string) {
+	delete(m.m, key)
+}
+
+func (m *IdleMap[V]) Len() int {
+	return len(m.m)
+}
+
+// evict drops idle entries, and the least recently accessed one if there are
+// still too many. Sweeps happen at most once every idleSweepInterval unless
+// the map is full.
+func (m *IdleMap[V]) evict(now time.Time) {
+	if len(m.m) < m.max && now.Sub(m.swept) < idleSweepInterval {
+		return
+	}
+	m.swept = now
+	var (
+		oldestKey string
+		oldest    time.Time
+	)
+	for key, e := range m.m {
+		if now.Sub(e.lastAccess) > m.idle {
+			delete(m.m, key)
+			continue
+		}
+		if oldestKey == "" || e.lastAccess.Before(oldest) {
+			oldestKey, oldest = key, e.lastAccess
+		}
+	}
+	if len(m.m) >= m.max {
+		delete(m.m, oldestKey)
+	}
+}
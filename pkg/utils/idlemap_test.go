@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIdleMapEvictIdle(t *testing.T) {
+	m := NewIdleMap[int](time.Hour, 10)
+	start := time.Now()
+	m.Set("idle", 1, start)
+	m.Set("busy", 1, start)
+	m.Get("busy", start.Add(time.Hour))
+	m.Set("new", 1, start.Add(time.Hour+time.Second))
+	if _, ok := m.Get("idle", start.Add(time.Hour+time.Second)); ok {
+		t.Error("idle entry not evicted")
+	}
+	for _, key := range []string{"busy", "new"} {
+		if _, ok := m.Get(key, start.Add(time.Hour+time.Second)); !ok {
+			t.Errorf("entry %s evicted", key)
+		}
+	}
+}
+
+func TestIdleMapSweepInterval(t *testing.T) {
+	m := NewIdleMap[int](time.Second, 10)
+	start := time.Now()
+	m.Set("a", 1, start)
+	m.Set("b", 1, start.Add(idleSweepInterval))
+	// idle for longer than a second, but swept less than a minute ago
+	m.Set("c", 1, start.Add(idleSweepInterval+30*time.Second))
+	if m.Len() != 2 {
+		t.Fatalf("got %d entries, want 2", m.Len())
+	}
+	m.Set("d", 1, start.Add(2*idleSweepInterval))
+	if m.Len() != 1 {
+		t.Fatalf("got %d entries after sweep, want 1", m.Len())
+	}
+}
+
+func TestIdleMapEvictLeastRecentlyUsed(t *testing.T) {
+	const max = 100
+	m := NewIdleMap[int](time.Hour, max)
+	now := time.Now()
+	for i := 0; i < max; i++ {
+		m.Set(fmt.Sprint(i), i, now.Add(time.Duration(i)*time.Millisecond))
+	}
+	m.Get("0", now.Add(time.Second))
+	m.Set("extra", 0, now.Add(time.Second))
+	if m.Len() != max {
+		t.Fatalf("got %d entries, want %d", m.Len(), max)
+	}
+	if _, ok := m.Get("1", now.Add(time.Second)); ok {
+		t.Error("least recently used entry not evicted")
+	}
+	if _, ok := m.Get("0", now.Add(time.Second)); !ok {
+		t.Error("recently used entry evicted")
+	}
+	// overwriting an entry doesn't evict another one
+	m.Set("extra", 1, now.Add(2*time.Second))
+	if v, _ := m.Get("extra", now.Add(2*time.Second)); v != 1 || m.Len() != max {
+		t.Errorf("overwrite: got value %d and %d entries", v, m.Len())
+	}
+}
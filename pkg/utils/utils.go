@@ -1,19 +1,68 @@
 package utils
 
 import (
+	"slices"
 	"sort"
-	"strings"
 
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/util/strutil"
 )
 
-var formalizeReplacer = strings.NewReplacer("-", "_", ".", "_")
-
+// FormalizeLabelName replaces every character not allowed in label names
+// with `_`, the same as prometheus service discoveries do for metadata keys.
 func FormalizeLabelName(s string) string {
-	return formalizeReplacer.Replace(s)
+	return strutil.SanitizeLabelName(s)
+}
+
+// Relabel applies relabel configs to every target with the labels of its
+// group merged, the same as prometheus does. Every target kept is returned
+// in a targetgroup of its own, targets left without address are dropped.
+func Relabel(tgs []*targetgroup.Group, cfgs ...*relabel.Config) []*targetgroup.Group {
+	if len(cfgs) == 0 {
+		return tgs
+	}
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	var ret []*targetgroup.Group
+	for _, tg := range tgs {
+		if tg == nil {
+			continue
+		}
+		for _, target := range tg.Targets {
+			lb.Reset(labels.EmptyLabels())
+			for k, v := range tg.Labels {
+				lb.Set(string(k), string(v))
+			}
+			for k, v := range target {
+				lb.Set(string(k), string(v))
+			}
+			if !relabel.ProcessBuilder(lb, cfgs...) {
+				continue
+			}
+			addr := lb.Get(model.AddressLabel)
+			if addr == "" {
+				continue
+			}
+			g := &targetgroup.Group{
+				Source:  tg.Source,
+				Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(addr)}},
+				Labels:  model.LabelSet{},
+			}
+			lb.Range(func(l labels.Label) {
+				if l.Name != model.AddressLabel {
+					g.Labels[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+				}
+			})
+			ret = append(ret, g)
+		}
+	}
+	return ret
 }
 
+// Grouping merges targetgroups with the same labels, the given targetgroups
+// are left untouched since they may be cached.
 func Grouping(tgs []*targetgroup.Group) []*targetgroup.Group {
 	m := make(map[model.Fingerprint]*targetgroup.Group)
 	var fpset model.Fingerprints
@@ -22,7 +71,9 @@ func Grouping(tgs []*targetgroup.Group) []*targetgroup.Group {
 		if v, ok := m[fingerprint]; ok {
 			v.Targets = append(v.Targets, tgs[i].Targets...)
 		} else {
-			m[fingerprint] = tgs[i]
+			g := *tgs[i]
+			g.Targets = slices.Clone(g.Targets)
+			m[fingerprint] = &g
 			fpset = append(fpset, fingerprint)
 		}
 	}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+)
+
+func TestFormalizeLabelName(t *testing.T) {
+	for in, want := range map[string]string{
+		"zone":            "zone",
+		"management.port": "management_port",
+		"zone-name":       "zone_name",
+		"@class":          "_class",
+		"git:commit":      "git_commit",
+		"a b/c":           "a_b_c",
+	} {
+		if got := FormalizeLabelName(in); got != want {
+			t.Errorf("FormalizeLabelName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func relabelConfigs(t *testing.T, s string) []*relabel.Config {
+	t.Helper()
+	var cfgs []*relabel.Config
+	if err := yaml.UnmarshalStrict([]byte(s), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+	return cfgs
+}
+
+// addresses returns the labels of every target by address, group labels
+// merged.
+func addresses(tgs []*targetgroup.Group) map[string]labels.Labels {
+	ret := map[string]labels.Labels{}
+	for _, tg := range tgs {
+		if tg == nil {
+			continue
+		}
+		for _, target := range tg.Targets {
+			m := map[string]string{}
+			for k, v := range tg.Labels.Merge(target) {
+				m[string(k)] = string(v)
+			}
+			ret[m[model.AddressLabel]] = labels.FromMap(m)
+		}
+	}
+	return ret
+}
+
+func TestRelabel(t *testing.T) {
+	tgs := []*targetgroup.Group{
+		{
+			Source: "api",
+			Labels: model.LabelSet{"env": "prod", "__meta_app": "api"},
+			Targets: []model.LabelSet{
+				{model.AddressLabel: "10.0.0.1:80", "__meta_zone": "a"},
+				{model.AddressLabel: "10.0.0.2:80", "__meta_zone": "b"},
+				{model.AddressLabel: "10.0.0.3:80", "__meta_zone": "a", "env": "canary"},
+			},
+		},
+		nil,
+		{
+			Source:  "db",
+			Labels:  model.LabelSet{"env": "dev", "__meta_app": "db"},
+			Targets: []model.LabelSet{{model.AddressLabel: "10.0.1.1:5432", "__meta_zone": "c"}},
+		},
+	}
+	for _, tc := range []struct {
+		name    string
+		configs string
+		want    []string
+	}{
+		{
+			name:    "group labels are merged before rules run",
+			configs: "- source_labels: [env]\n  regex: prod\n  action: keep\n",
+			want:    []string{"10.0.0.1:80", "10.0.0.2:80"},
+		},
+		{
+			name:    "drop",
+			configs: "- source_labels: [__meta_app, __meta_zone]\n  regex: api;a\n  action: drop\n",
+			want:    []string{"10.0.0.2:80", "10.0.1.1:5432"},
+		},
+		{
+			name:    "hashmod",
+			configs: "- source_labels: [__address__]\n  modulus: 2\n  target_label: __tmp_hash\n  action: hashmod\n- source_labels: [__tmp_hash]\n  regex: 0\n  action: keep\n",
+		},
+		{
+			name:    "labelmap",
+			configs: "- regex: __meta_(.+)\n  action: labelmap\n",
+			want:    []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.1.1:5432"},
+		},
+		{
+			name:    "targets left without address are dropped",
+			configs: "- source_labels: [__meta_zone]\n  regex: a\n  target_label: __address__\n  replacement: ''\n",
+			want:    []string{"10.0.0.2:80", "10.0.1.1:5432"},
+		},
+		{
+			name:    "everything dropped",
+			configs: "- action: drop\n  regex: .*\n  source_labels: [env]\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfgs := relabelConfigs(t, tc.configs)
+			got := Relabel(tgs, cfgs...)
+			for _, tg := range got {
+				if len(tg.Targets) == 0 {
+					t.Errorf("empty targetgroup %s", tg.Source)
+				}
+			}
+			// what prometheus would scrape
+			want := map[string]labels.Labels{}
+			for _, ls := range addresses(tgs) {
+				if ls, keep := relabel.Process(ls, cfgs...); keep && ls.Get(model.AddressLabel) != "" {
+					want[ls.Get(model.AddressLabel)] = ls
+				}
+			}
+			if gotLabels := addresses(got); !reflect.DeepEqual(gotLabels, want) {
+				t.Errorf("got %v, want %v", gotLabels, want)
+			} else if tc.want != nil {
+				for _, addr := range tc.want {
+					if _, ok := gotLabels[addr]; !ok {
+						t.Errorf("target %s dropped", addr)
+					}
+				}
+				if len(gotLabels) != len(tc.want) {
+					t.Errorf("got targets %v, want %v", gotLabels, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRelabelWithoutConfigs(t *testing.T) {
+	tgs := []*targetgroup.Group{{Targets: []model.LabelSet{{model.AddressLabel: "a:80"}}}}
+	if got := Relabel(tgs); !reflect.DeepEqual(got, tgs) {
+		t.Errorf("got %v, want targetgroups as they are", got)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/fengxsong/httpsd/pkg/discovery"
+)
+
+type discovererStatus struct {
+	discovery.Status
+	// Stale is true if the last completed sync is older than the threshold.
+	Stale bool `json:"stale,omitempty"`
+}
+
+type readiness struct {
+	Ready       bool                        `json:"ready"`
+	Discoverers map[string]discovererStatus `json:"discoverers"`
+}
+
+// readiness returns the status of all discoverers, the ones which don't sync
+// in background are always ready.
+func (h *sdHandler) readiness(now time.Time) readiness {
+	h.mu.RLock()
+	discoverers := h.discoverer
+	h.mu.RUnlock()
+
+	r := readiness{Ready: true, Discoverers: make(map[string]discovererStatus, len(discoverers))}
+	for name, d := range discoverers {
+		status := discovererStatus{Status: discovery.Status{Ready: true}}
+		if reporter, ok := d.(discovery.StatusReporter); ok {
+			status.Status = reporter.Status()
+			if h.readyMaxStaleness > 0 && status.Ready {
+				status.Stale = status.LastSync == nil || now.Sub(*status.LastSync) > h.readyMaxStaleness
+			}
+		}
+		if !status.Ready || status.Stale {
+			r.Ready = false
+		}
+		r.Discoverers[name] = status
+	}
+	return r
+}
+
+// serveReady responds with 503 until every discoverer is ready.
+func (h *sdHandler) serveReady(w http.ResponseWriter, req *http.Request) {
+	r := h.readiness(time.Now())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if !r.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(r)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/fengxsong/httpsd/pkg/discovery"
+)
+
+type staticDiscoverer struct{}
+
+func (staticDiscoverer) Refresh(context.Context, url.Values) ([]*targetgroup.Group, error) {
+	return nil, nil
+}
+
+type statusDiscoverer struct {
+	staticDiscoverer
+	status discovery.Status
+}
+
+func (d statusDiscoverer) Status() discovery.Status { return d.status }
+
+func TestReadiness(t *testing.T) {
+	now := time.Now()
+	recent, old := now.Add(-time.Minute), now.Add(-time.Hour)
+	for _, tc := range []struct {
+		name         string
+		status       discovery.Status
+		maxStaleness time.Duration
+		ready        bool
+		stale        bool
+	}{
+		{name: "not synced", status: discovery.Status{}},
+		{name: "synced", status: discovery.Status{Ready: true, LastSync: &recent}, ready: true},
+		{name: "staleness disabled", status: discovery.Status{Ready: true, LastSync: &old}, ready: true},
+		{name: "fresh", status: discovery.Status{Ready: true, LastSync: &recent}, maxStaleness: 10 * time.Minute, ready: true},
+		{name: "stale", status: discovery.Status{Ready: true, LastSync: &old}, maxStaleness: 10 * time.Minute, stale: true},
+		// a service always failing doesn't make the discoverer stale
+		{name: "partial failures", status: discovery.Status{Ready: true, LastSync: &recent, LastError: "service a: not found"}, maxStaleness: 10 * time.Minute, ready: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &sdHandler{
+				readyMaxStaleness: tc.maxStaleness,
+				discoverer: map[string]discovery.Discoverer{
+					"http":  staticDiscoverer{},
+					"nacos": statusDiscoverer{status: tc.status},
+				},
+			}
+			r := h.readiness(now)
+			if r.Ready != tc.ready {
+				t.Errorf("ready = %t, want %t", r.Ready, tc.ready)
+			}
+			if got := r.Discoverers["nacos"].Stale; got != tc.stale {
+				t.Errorf("stale = %t, want %t", got, tc.stale)
+			}
+		})
+	}
+}
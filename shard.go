@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+const (
+	shardParam       = "shard"
+	shardsParam      = "shards"
+	shardLabelParam  = "shard_label"
+	shardMethodParam = "shard_method"
+
+	// hashmod is the same as the hashmod relabel action of prometheus, so
+	// shards can be moved between httpsd and relabel configs freely
+	shardMethodHashmod = "hashmod"
+	// consistent is jump consistent hash, which moves about 1/shards of the
+	// targets only when the number of shards changes
+	shardMethodConsistent = "consistent"
+)
+
+// sharding selects the targets assigned to one of the shards by hash of
+// their labels, merged with the ones of their group.
+type sharding struct {
+	shard  uint64
+	shards uint64
+	labels []model.LabelName
+	method string
+}
+
+// parseSharding parses the `shard`, `shards`, `shard_label` and
+// `shard_method` query parameters and removes them from q. It returns nil if
+// `shards` is absent.
+func parseSharding(q url.Values) (*sharding, error) {
+	defer func() {
+		for _, k := range []string{shardParam, shardsParam, shardLabelParam, shardMethodParam} {
+			q.Del(k)
+		}
+	}()
+	if !q.Has(shardsParam) {
+		if q.Has(shardParam) {
+			return nil, fmt.Errorf("%s is missing", shardsParam)
+		}
+		return nil, nil
+	}
+	s := &sharding{
+		labels: []model.LabelName{model.AddressLabel},
+		method: shardMethodHashmod,
+	}
+	var err error
+	if s.shards, err = strconv.ParseUint(q.Get(shardsParam), 10, 64); err != nil || s.shards == 0 {
+		return nil, fmt.Errorf("invalid %s %q, must be a positive integer", shardsParam, q.Get(shardsParam))
+	}
+	if s.shard, err = strconv.ParseUint(q.Get(shardParam), 10, 64); err != nil || s.shard >= s.shards {
+		return nil, fmt.Errorf("invalid %s %q, must be in [0, %d)", shardParam, q.Get(shardParam), s.shards)
+	}
+	if names := q[shardLabelParam]; len(names) > 0 {
+		s.labels = s.labels[:0]
+		for _, name := range names {
+			if !model.LabelName(name).IsValid() {
+				return nil, fmt.Errorf("invalid %s %q", shardLabelParam, name)
+			}
+			s.labels = append(s.labels, model.LabelName(name))
+		}
+	}
+	switch method := q.Get(shardMethodParam); method {
+	case "", shardMethodHashmod:
+	case shardMethodConsistent:
+		s.method = method
+	default:
+		return nil, fmt.Errorf("unknown %s %q, must be %s or %s", shardMethodParam, method, shardMethodHashmod, shardMethodConsistent)
+	}
+	return s, nil
+}
+
+// filter returns new targetgroups holding the targets of the shard.
+func (s *sharding) filter(tgs []*targetgroup.Group) []*targetgroup.Group {
+	if s == nil {
+		return tgs
+	}
+	return selectTargets(tgs, func(tg *targetgroup.Group, target model.LabelSet) bool {
+		return s.assign(tg.Labels.Merge(target)) == s.shard
+	})
+}
+
+// assign returns the shard of a target, the values of labels are joined by
+// `;` as the source labels of relabel configs.
+func (s *sharding) assign(ls model.LabelSet) uint64 {
+	values := make([]string, 0, len(s.labels))
+	for _, name := range s.labels {
+		values = append(values, string(ls[name]))
+	}
+	hash := md5.Sum([]byte(strings.Join(values, ";")))
+	key := binary.BigEndian.Uint64(hash[8:])
+	if s.method == shardMethodConsistent {
+		return uint64(jumpHash(key, int64(s.shards)))
+	}
+	return key % s.shards
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach,
+// https://arxiv.org/abs/1406.2294.
+func jumpHash(key uint64, buckets int64) int64 {
+	var b, j int64 = -1, 0
+	for j < buckets {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return b
+}
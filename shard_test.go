@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+func TestParseSharding(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		q    url.Values
+		want *sharding
+		err  bool
+	}{
+		{name: "none", q: url.Values{}},
+		{name: "defaults", q: url.Values{"shard": {"1"}, "shards": {"3"}}, want: &sharding{shard: 1, shards: 3, labels: []model.LabelName{model.AddressLabel}, method: shardMethodHashmod}},
+		{name: "labels", q: url.Values{"shard": {"0"}, "shards": {"2"}, "shard_label": {"job", "instance"}, "shard_method": {"consistent"}}, want: &sharding{shard: 0, shards: 2, labels: []model.LabelName{"job", "instance"}, method: shardMethodConsistent}},
+		{name: "shard without shards", q: url.Values{"shard": {"1"}}, err: true},
+		{name: "zero shards", q: url.Values{"shard": {"0"}, "shards": {"0"}}, err: true},
+		{name: "shard out of range", q: url.Values{"shard": {"3"}, "shards": {"3"}}, err: true},
+		{name: "missing shard", q: url.Values{"shards": {"3"}}, err: true},
+		{name: "invalid label", q: url.Values{"shard": {"0"}, "shards": {"2"}, "shard_label": {"a-b"}}, err: true},
+		{name: "unknown method", q: url.Values{"shard": {"0"}, "shards": {"2"}, "shard_method": {"random"}}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := url.Values{"serviceName": {"a"}}
+			for k, v := range tc.q {
+				q[k] = v
+			}
+			s, err := parseSharding(q)
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v, want error %t", err, tc.err)
+			}
+			if fmt.Sprint(s) != fmt.Sprint(tc.want) {
+				t.Errorf("sharding = %+v, want %+v", s, tc.want)
+			}
+			if len(q) != 1 || q.Get("serviceName") != "a" {
+				t.Errorf("sharding parameters left in query %v", q)
+			}
+		})
+	}
+}
+
+// TestHashmodParity checks targets are assigned to the same shards as the
+// hashmod relabel action of prometheus does.
+func TestHashmodParity(t *testing.T) {
+	const shards = 7
+	for _, sourceLabels := range [][]model.LabelName{{model.AddressLabel}, {"job", "instance"}} {
+		s := &sharding{shards: shards, labels: sourceLabels, method: shardMethodHashmod}
+		cfg := &relabel.Config{
+			SourceLabels: sourceLabels,
+			Separator:    ";",
+			Modulus:      shards,
+			TargetLabel:  "__tmp_shard",
+			Action:       relabel.HashMod,
+		}
+		for i := 0; i < 200; i++ {
+			ls := model.LabelSet{
+				model.AddressLabel: model.LabelValue(fmt.Sprintf("10.0.%d.%d:9100", i/10, i)),
+				"job":              model.LabelValue(fmt.Sprintf("job-%d", i%3)),
+				"instance":         model.LabelValue(fmt.Sprint(i)),
+			}
+			lb := labels.NewBuilder(labels.EmptyLabels())
+			for k, v := range ls {
+				lb.Set(string(k), string(v))
+			}
+			relabeled, _ := relabel.Process(lb.Labels(), cfg)
+			want := relabeled.Get("__tmp_shard")
+			if got := fmt.Sprint(s.assign(ls)); got != want {
+				t.Fatalf("shard of %v by %v = %s, prometheus hashmod = %s", ls, sourceLabels, got, want)
+			}
+		}
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	// vectors of the reference implementation
+	for _, tc := range []struct {
+		key     uint64
+		buckets int64
+		want    int64
+	}{
+		{key: 1, buckets: 1, want: 0},
+		{key: 42, buckets: 57, want: 43},
+		{key: 0xDEAD10CC, buckets: 1, want: 0},
+		{key: 0xDEAD10CC, buckets: 666, want: 361},
+		{key: 256, buckets: 1024, want: 520},
+	} {
+		if got := jumpHash(tc.key, tc.buckets); got != tc.want {
+			t.Errorf("jumpHash(%d, %d) = %d, want %d", tc.key, tc.buckets, got, tc.want)
+		}
+	}
+	// growing the buckets only moves keys into the new bucket
+	for key := uint64(0); key < 1000; key++ {
+		prev := jumpHash(key, 1)
+		for buckets := int64(2); buckets <= 32; buckets++ {
+			got := jumpHash(key, buckets)
+			if got < 0 || got >= buckets {
+				t.Fatalf("jumpHash(%d, %d) = %d out of range", key, buckets, got)
+			}
+			if got != prev && got != buckets-1 {
+				t.Fatalf("key %d moved from %d to %d growing to %d buckets", key, prev, got, buckets)
+			}
+			prev = got
+		}
+	}
+}
+
+func TestShardingFilter(t *testing.T) {
+	var targets []model.LabelSet
+	for i := 0; i < 100; i++ {
+		targets = append(targets, model.LabelSet{model.AddressLabel: model.LabelValue(fmt.Sprintf("host-%d:80", i))})
+	}
+	tgs := []*targetgroup.Group{{Targets: targets, Labels: model.LabelSet{"job": "node"}, Source: "0"}}
+	for _, method := range []string{shardMethodHashmod, shardMethodConsistent} {
+		seen := map[model.LabelValue]int{}
+		for shard := uint64(0); shard < 3; shard++ {
+			s := &sharding{shard: shard, shards: 3, labels: []model.LabelName{model.AddressLabel}, method: method}
+			for _, tg := range s.filter(tgs) {
+				if tg.Labels["job"] != "node" || tg.Source != "0" {
+					t.Errorf("labels or source of group lost: %v", tg)
+				}
+				for _, target := range tg.Targets {
+					seen[target[model.AddressLabel]]++
+				}
+			}
+		}
+		if len(seen) != len(targets) {
+			t.Errorf("%s: %d of %d targets assigned", method, len(seen), len(targets))
+		}
+		for addr, n := range seen {
+			if n != 1 {
+				t.Errorf("%s: target %s assigned to %d shards", method, addr, n)
+			}
+		}
+	}
+	if len(tgs[0].Targets) != 100 {
+		t.Error("filter mutated its input")
+	}
+}
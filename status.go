@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"gopkg.in/yaml.v2"
+
+	"github.com/fengxsong/httpsd/pkg/discovery"
+)
+
+// refreshStatus is the outcome of the last refresh of a discoverer served
+// by the targets endpoint or written into file_sd outputs.
+type refreshStatus struct {
+	Time     time.Time `json:"time"`
+	Query    string    `json:"query"`
+	Duration float64   `json:"durationSeconds"`
+	Targets  int       `json:"targets"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func (h *sdHandler) observeRefresh(name string, q url.Values, start time.Time, tgs []*targetgroup.Group, err error) {
+	status := refreshStatus{
+		Time:     start,
+		Query:    q.Encode(),
+		Duration: time.Since(start).Seconds(),
+	}
+	for _, tg := range tgs {
+		status.Targets += len(tg.Targets)
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	h.refreshesMu.Lock()
+	defer h.refreshesMu.Unlock()
+	if h.refreshes == nil {
+		h.refreshes = map[string]refreshStatus{}
+	}
+	h.refreshes[name] = status
+}
+
+type discovererInfo struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+	// BuildError is set if the discoverer was skipped by the last reload.
+	BuildError  string            `json:"buildError,omitempty"`
+	Config      string            `json:"config,omitempty"`
+	Status      *discovery.Status `json:"status,omitempty"`
+	LastRefresh *refreshStatus    `json:"lastRefresh,omitempty"`
+}
+
+// discoverers returns all registered discoverers sorted by name.
+func (h *sdHandler) discoverers() []discovererInfo {
+	h.mu.RLock()
+	discoverers, buildErrors := h.discoverer, h.buildErrors
+	h.mu.RUnlock()
+	h.refreshesMu.Lock()
+	defer h.refreshesMu.Unlock()
+
+	var infos []discovererInfo
+	for name := range discovery.All() {
+		info := discovererInfo{Name: name, Default: name == h.defaultT}
+		if d, ok := discoverers[name]; ok {
+			if c, ok := d.(discovery.Configurer); ok {
+				if b, err := yaml.Marshal(c.Config()); err != nil {
+					info.Config = "failed to marshal config: " + err.Error()
+				} else {
+					info.Config = string(b)
+				}
+			}
+			if r, ok := d.(discovery.StatusReporter); ok {
+				status := r.Status()
+				info.Status = &status
+			}
+		} else {
+			info.BuildError = buildErrors[name]
+		}
+		if r, ok := h.refreshes[name]; ok {
+			info.LastRefresh = &r
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+func (h *sdHandler) serveStatusAPI(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(struct {
+		Discoverers []discovererInfo `json:"discoverers"`
+	}{h.discoverers()})
+}
+
+func (h *sdHandler) serveStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, struct {
+		TargetsPath string
+		Discoverers []discovererInfo
+	}{h.path, h.discoverers()}); err != nil {
+		httpErrorWithLogging(w, h.logger, "", err)
+	}
+}
+
+type targetRow struct {
+	Address string
+	Labels  []string
+}
+
+// serveStatusTargets renders the targetgroups of the discoverer selected by
+// the same query parameters as the targets endpoint, which can be given in
+// the `query` parameter as well.
+func (h *sdHandler) serveStatusTargets(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	data := struct {
+		Discoverer string
+		Query      string
+		Error      string
+		Rows       []targetRow
+	}{}
+	if raw := q.Get("query"); raw != "" {
+		q.Del("query")
+		extra, err := url.ParseQuery(raw)
+		if err != nil {
+			data.Error = err.Error()
+		}
+		for k, v := range extra {
+			q[k] = append(q[k], v...)
+		}
+	}
+	var (
+		tgs []*targetgroup.Group
+		err error
+	)
+	if data.Error == "" {
+		data.Discoverer, tgs, err = h.targets(req.Context(), q)
+		if err != nil {
+			data.Error = err.Error()
+		}
+	}
+	if data.Discoverer == "" {
+		data.Discoverer = q.Get("discovery")
+	}
+	q.Del("discovery")
+	data.Query = q.Encode()
+	for _, tg := range tgs {
+		for _, target := range tg.Targets {
+			labels := tg.Labels.Merge(target)
+			row := targetRow{Address: string(labels[model.AddressLabel])}
+			for name, value := range labels {
+				if name != model.AddressLabel {
+					row.Labels = append(row.Labels, string(name)+"="+string(value))
+				}
+			}
+			slices.Sort(row.Labels)
+			data.Rows = append(data.Rows, row)
+		}
+	}
+	sort.Slice(data.Rows, func(i, j int) bool { return data.Rows[i].Address < data.Rows[j].Address })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err = targetsTemplate.Execute(w, data); err != nil {
+		httpErrorWithLogging(w, h.logger, "", err)
+	}
+}
+
+const statusStyle = `<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.error { color: #b00; }
+pre { margin: 0; }
+code { display: inline-block; margin-right: 6px; }
+</style>`
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"since": func(t time.Time) string { return time.Since(t).Truncate(time.Second).String() + " ago" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>httpsd status</title>` + statusStyle + `</head>
+<body>
+<h1>Discoverers</h1>
+<table>
+<tr><th>Name</th><th>State</th><th>Sync</th><th>Last refresh</th><th>Targets</th><th>Config</th></tr>
+{{- range .Discoverers }}
+<tr>
+<td><a href="status/targets?discovery={{ .Name }}">{{ .Name }}</a>{{ if .Default }} (default){{ end }}</td>
+{{- if .BuildError }}
+<td class="error">failed to build: {{ .BuildError }}</td>
+{{- else }}
+<td>running</td>
+{{- end }}
+<td>{{ with .Status }}{{ if .Ready }}ready{{ else }}not ready{{ end }}{{ with .LastSync }}, last sync {{ since . }}{{ end }}{{ with .LastSuccess }}, last success {{ since . }}{{ end }}{{ with .LastError }}<div class="error">{{ . }}</div>{{ end }}{{ end }}</td>
+<td>{{ with .LastRefresh }}{{ since .Time }}{{ with .Query }} <code>{{ . }}</code>{{ end }}{{ with .Error }}<div class="error">{{ . }}</div>{{ end }}{{ end }}</td>
+<td>{{ with .LastRefresh }}{{ .Targets }}{{ end }}</td>
+<td><pre>{{ .Config }}</pre></td>
+</tr>
+{{- end }}
+</table>
+<p>Targets are served at <a href="{{ .TargetsPath }}">{{ .TargetsPath }}</a>, see also <a href="api/v1/status">api/v1/status</a>.</p>
+</body>
+</html>
+`))
+
+var targetsTemplate = template.Must(template.New("targets").Parse(`<!DOCTYPE html>
+<html>
+<head><title>httpsd targets of {{ .Discoverer }}</title>` + statusStyle + `</head>
+<body>
+<p><a href="../status">Discoverers</a></p>
+<h1>Targets of {{ .Discoverer }}</h1>
+<form>
+<input type="hidden" name="discovery" value="{{ .Discoverer }}">
+<input type="text" name="query" size="80" value="{{ .Query }}" placeholder="query parameters, eg. serviceName=foo">
+<input type="submit" value="Refresh">
+</form>
+{{- with .Error }}
+<p class="error">{{ . }}</p>
+{{- end }}
+<table>
+<tr><th>Address</th><th>Labels</th></tr>
+{{- range .Rows }}
+<tr><td>{{ .Address }}</td><td>{{ range .Labels }}<code>{{ . }}</code>{{ end }}</td></tr>
+{{- end }}
+</table>
+<p>{{ len .Rows }} targets</p>
+</body>
+</html>
+`))
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/fengxsong/httpsd/pkg/decoder"
+	httpdiscovery "github.com/fengxsong/httpsd/pkg/discovery/http"
+	"github.com/fengxsong/httpsd/pkg/transformer"
+	"github.com/fengxsong/httpsd/pkg/utils"
+)
+
+type transformOptions struct {
+	ttype    string
+	config   string
+	source   string
+	input    string
+	expected string
+	// query is the query of the targets endpoint the transformer is given,
+	// eg. `status=UP` of eureka.
+	query string
+}
+
+// transform feeds a local response body through the transformer of a source
+// and prints the resulting targetgroups, or the diff against the expected
+// output if any.
+func transform(w io.Writer, o *transformOptions) int {
+	q, err := url.ParseQuery(o.query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid query %q: %s\n", o.query, err)
+		return failureExitCode
+	}
+	sc, err := transformSource(o)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return failureExitCode
+	}
+	tr, err := httpdiscovery.NewTransformer(sc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return failureExitCode
+	}
+	b, err := os.ReadFile(o.input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return failureExitCode
+	}
+	if sc.ResponseFormat != "" {
+		dec := decoder.Get(sc.ResponseFormat)
+		if dec == nil {
+			fmt.Fprintf(os.Stderr, "unknown response format %q\n", sc.ResponseFormat)
+			return failureExitCode
+		}
+		if b, err = decoder.ToJSON(dec, b); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return failureExitCode
+		}
+	}
+	tgs, err := tr.Transform(transformer.WithQuery(context.Background(), q), b)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to transform:", err)
+		return failureExitCode
+	}
+	for _, tg := range tgs {
+		if tg == nil {
+			fmt.Fprintln(os.Stderr, "failed to transform: nil target group item found")
+			return failureExitCode
+		}
+	}
+	out, err := encodeTargetgroups(utils.Grouping(utils.Relabel(tgs, sc.RelabelConfigs...)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return failureExitCode
+	}
+
+	if o.expected == "" {
+		w.Write(out)
+		return successExitCode
+	}
+	b, err = os.ReadFile(o.expected)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return failureExitCode
+	}
+	var expectedTgs []*targetgroup.Group
+	if err = json.Unmarshal(b, &expectedTgs); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse %s: %s\n", o.expected, err)
+		return failureExitCode
+	}
+	expected, err := encodeTargetgroups(expectedTgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return failureExitCode
+	}
+	if bytes.Equal(out, expected) {
+		return successExitCode
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(expected)),
+		B:        difflib.SplitLines(string(out)),
+		FromFile: o.expected,
+		ToFile:   o.input,
+		Context:  3,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return failureExitCode
+	}
+	fmt.Fprint(w, diff)
+	return failureExitCode
+}
+
+// transformSource returns the selected source of the config file, or an
+// empty one if no config file is specified. The --type flag overrides the
+// transformer type of the source.
+func transformSource(o *transformOptions) (*httpdiscovery.SDConfig, error) {
+	sc, err := selectSource(o.config, o.source)
+	if err != nil {
+		return nil, err
+	}
+	if o.ttype != "" {
+		sc.Type = o.ttype
+	}
+	if sc.Type == "" {
+		sc.Type = defaultTransformerType
+	}
+	return sc, nil
+}
+
+func selectSource(path, name string) (*httpdiscovery.SDConfig, error) {
+	if path == "" {
+		return &httpdiscovery.SDConfig{}, nil
+	}
+	cfg, err := httpdiscovery.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, sc := range cfg.Sources {
+		if sc != nil && (name == "" || sc.Name == name) {
+			return sc, nil
+		}
+	}
+	return nil, fmt.Errorf("source %q not found in %s", name, path)
+}
+
+func encodeTargetgroups(tgs []*targetgroup.Group) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(tgs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	null := filepath.Join(t.TempDir(), "null.json")
+	if err := os.WriteFile(null, []byte("[null]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	apps := filepath.Join("pkg", "transformer", "eureka", "testdata", "apps.json")
+	for _, tc := range []struct {
+		name    string
+		o       transformOptions
+		code    int
+		targets []string
+	}{
+		{name: "nil group", o: transformOptions{input: null}, code: failureExitCode},
+		{name: "no query", o: transformOptions{ttype: "eureka", input: apps}, targets: []string{"10.0.0.1:8080", "user-1.example.com:9090"}},
+		{name: "query", o: transformOptions{ttype: "eureka", input: apps, query: "status=UP"}, targets: []string{"10.0.0.1:8080"}},
+		{name: "invalid query", o: transformOptions{ttype: "eureka", input: apps, query: "status=%zz"}, code: failureExitCode},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if code := transform(&out, &tc.o); code != tc.code {
+				t.Fatalf("exit code = %d, want %d", code, tc.code)
+			}
+			for _, target := range []string{"10.0.0.1:8080", "user-1.example.com:9090"} {
+				want := false
+				for _, t := range tc.targets {
+					want = want || t == target
+				}
+				if got := strings.Contains(out.String(), target); got != want {
+					t.Errorf("target %s in output = %t, want %t", target, got, want)
+				}
+			}
+		})
+	}
+}